@@ -0,0 +1,440 @@
+// Package cache implements a caching decorator for a TopContributorGetter,
+// so that repeated queries for the same location don't reach GitHub API
+package cache
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adriansr/github-api-service/metrics"
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// Cache is a TopContributorGetter that keeps the results obtained from
+// another TopContributorGetter for a limited time. When the maximum number
+// of entries is reached, the least recently used entry is evicted.
+//
+// Expired entries can still be served, flagged as Stale, during the
+// revalidation window (while they are refreshed in the background) and
+// the max stale window (when refreshing them fails), both counted from
+// the expiration time.
+type Cache struct {
+	getter     model.TopContributorGetter
+	ttl        time.Duration
+	maxEntries int
+	// stale-while-revalidate window
+	revalidate time.Duration
+	// stale-on-error window
+	maxStale time.Duration
+	// tracks background refreshes
+	refreshes sync.WaitGroup
+
+	// protects all fields below
+	mutex sync.Mutex
+	// map from key to the element in the lru list
+	entries map[string]*list.Element
+	// list of *Entry, most recently used at the front
+	lru *list.List
+	// persists the entries, if set
+	backing Store
+	// changes to persist, in order. They are written without holding the
+	// mutex, so that queries don't wait for the disk
+	pending []change
+	// set while the pending changes are being written
+	flushing bool
+	// keys being refreshed in the background
+	refreshing map[string]bool
+	// counters of the queries served
+	stats Stats
+	// set when no more background refreshes must be started
+	closed bool
+
+	// source of time, replaceable for testing
+	now func() time.Time
+}
+
+// Entry represents the cached result for a query
+type Entry struct {
+	Key string `json:"key"`
+	// count requested when the result was fetched
+	Count   int            `json:"count"`
+	Ranking *model.Ranking `json:"ranking"`
+	// when the result was stored in the cache
+	FetchedAt time.Time `json:"fetched_at"`
+	// time to live since FetchedAt
+	TTL time.Duration `json:"ttl"`
+	// time the entry is kept after expiring, to be served stale
+	Retain time.Duration `json:"retain,omitempty"`
+}
+
+// Expired returns if the entry has expired at the given time
+func (entry *Entry) Expired(now time.Time) bool {
+	return !now.Before(entry.expires())
+}
+
+// Discarded returns if the entry can no longer be served at the given time,
+// not even stale
+func (entry *Entry) Discarded(now time.Time) bool {
+	return !now.Before(entry.expires().Add(entry.Retain))
+}
+
+// (private) expires returns the expiration time of the entry
+func (entry *Entry) expires() time.Time {
+	return entry.FetchedAt.Add(entry.TTL)
+}
+
+// Stats counts the queries received by a cache
+type Stats struct {
+	// queries answered with a cached result, fresh or stale
+	Hits uint64
+	// queries forwarded to the underlying getter
+	Misses uint64
+}
+
+// (private) change is an update to persist: an entry to store or, when
+// deleted, the key of an entry to remove
+type change struct {
+	entry   Entry
+	deleted bool
+}
+
+// (private) freshness of a cached result
+type freshness int
+
+const (
+	// not cached, or can't be served
+	missing freshness = iota
+	// not expired
+	fresh
+	// expired, within the revalidation window
+	revalidate
+	// expired, can only be served if the query fails
+	stale
+)
+
+// Store is implemented by types that persist cache entries, like
+// DiskStore, so that they survive restarts
+type Store interface {
+	// Load returns the stored entries
+	Load() ([]Entry, error)
+	// Put stores an entry, replacing any other with the same key
+	Put(entry Entry) error
+	// Delete removes the entry with the given key, if any
+	Delete(key string) error
+}
+
+// New returns a Cache around the given getter, where results expire
+// after `ttl` and at most `maxEntries` locations are kept
+func New(getter model.TopContributorGetter, ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		getter:     getter,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		refreshing: make(map[string]bool),
+		now:        time.Now,
+	}
+}
+
+// SetStalePolicy allows expired entries to be served. During the
+// `revalidate` window after an entry expires, it is served immediately
+// while it's refreshed in the background. During the `maxStale` window,
+// it's served when the query to refresh it fails. Not safe to call while
+// queries are in progress
+func (cache *Cache) SetStalePolicy(revalidate, maxStale time.Duration) {
+	cache.revalidate, cache.maxStale = revalidate, maxStale
+}
+
+// GetTopContributors returns the cached top contributors for the query
+// when available, otherwise the query is forwarded to the underlying getter
+// and its result is cached. Incomplete rankings are not cached, so that
+// the next query tries to obtain the complete results. Expired entries are
+// served according to the stale policy, and are replaced by a result for at
+// least their count
+func (cache *Cache) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	key := query.Key()
+	cached, state := cache.lookup(key, query.Count)
+	switch state {
+	case fresh:
+		cache.count(true)
+		return cached, nil
+	case revalidate:
+		cache.count(true)
+		cache.refresh(ctx, key, query)
+		return cached, nil
+	}
+	ranking, err := cache.fetch(ctx, key, query)
+	if err != nil {
+		if state == stale {
+			cache.count(true)
+			slog.WarnContext(ctx, "Serving stale result after query failure", "error", err)
+			return cached, nil
+		}
+		cache.count(false)
+		return nil, err
+	}
+	cache.count(false)
+	return ranking, nil
+}
+
+// Refresh forwards the query to the underlying getter regardless of the
+// cached results, and caches the ranking obtained. Allows to keep entries
+// fresh ahead of the queries that need them. Like expired entries, the
+// refreshed entry keeps at least the count of the one it replaces
+func (cache *Cache) Refresh(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	return cache.fetch(ctx, query.Key(), query)
+}
+
+// (private) fetch forwards the query to the underlying getter and caches the
+// ranking obtained, returning up to the count of the query. At least the
+// count of the entry cached for the key is fetched, so that the entry that
+// replaces it can still serve the same queries
+func (cache *Cache) fetch(ctx context.Context, key string, query model.Query) (*model.Ranking, error) {
+	fetched := query
+	cache.mutex.Lock()
+	if element, found := cache.entries[key]; found {
+		fetched.Count = util.Max(query.Count, element.Value.(*Entry).Count)
+	}
+	cache.mutex.Unlock()
+	ranking, err := cache.getter.GetTopContributors(ctx, fetched)
+	if err != nil {
+		return nil, err
+	}
+	if !ranking.Incomplete {
+		cache.store(key, fetched.Count, ranking)
+	}
+	return first(ranking, query.Count), nil
+}
+
+// (private) refresh performs the query in the background to replace an
+// expired entry, unless it's already being refreshed. The refreshed entry
+// keeps at least the count of the expired one, so that it can serve the
+// same queries
+func (cache *Cache) refresh(ctx context.Context, key string, query model.Query) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.closed || cache.refreshing[key] {
+		return
+	}
+	cache.refreshing[key] = true
+	cache.refreshes.Add(1)
+	// the refresh outlives the request that triggered it
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer cache.refreshes.Done()
+		if _, err := cache.fetch(ctx, key, query); err != nil {
+			slog.ErrorContext(ctx, "Background refresh failed", "error", err)
+		}
+		cache.mutex.Lock()
+		delete(cache.refreshing, key)
+		cache.mutex.Unlock()
+	}()
+}
+
+// Close stops starting background refreshes and waits for the ones in
+// progress to finish, so that their results are stored before exiting
+func (cache *Cache) Close() {
+	cache.mutex.Lock()
+	cache.closed = true
+	cache.mutex.Unlock()
+	cache.refreshes.Wait()
+}
+
+// Persist restores the entries saved in the store that can still be served
+// and saves all future changes to it. Restored entries keep the TTL they
+// were stored with
+func (cache *Cache) Persist(store Store) error {
+	entries, err := store.Load()
+	if err != nil {
+		return err
+	}
+	// the most recently fetched entries become the most recently used
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].FetchedAt.Before(entries[j].FetchedAt)
+	})
+
+	defer cache.flush()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.backing = store
+	now := cache.now()
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Discarded(now) || entry.Ranking == nil {
+			cache.persistDelete(entry.Key)
+			continue
+		}
+		cache.add(entry)
+	}
+	return nil
+}
+
+// Len returns the number of entries currently in the cache
+func (cache *Cache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.lru.Len()
+}
+
+// Stats returns the number of queries answered so far
+func (cache *Cache) Stats() Stats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.stats
+}
+
+// RegisterMetrics registers the number of hits, misses and entries of the
+// cache in the given registry
+func (cache *Cache) RegisterMetrics(registry *metrics.Registry) {
+	registry.NewCounterFunc("ghas_cache_hits_total",
+		"Queries answered from the cache, fresh or stale.",
+		func() float64 { return float64(cache.Stats().Hits) })
+	registry.NewCounterFunc("ghas_cache_misses_total",
+		"Queries not answered from the cache.",
+		func() float64 { return float64(cache.Stats().Misses) })
+	registry.NewGaugeFunc("ghas_cache_entries",
+		"Entries in the cache.",
+		func() float64 { return float64(cache.Len()) })
+}
+
+// (private) count accounts a query as a hit or a miss
+func (cache *Cache) count(hit bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if hit {
+		cache.stats.Hits++
+	} else {
+		cache.stats.Misses++
+	}
+}
+
+// (private) lookup returns the ranking of the first `count` users stored for
+// a key, as long as the entry can be served and it has enough results to
+// satisfy `count`, along with its freshness. This allows a result fetched
+// for a larger count to be reused.
+func (cache *Cache) lookup(key string, count int) (*model.Ranking, freshness) {
+	// the entry is deleted from the store if discarded
+	defer cache.flush()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, found := cache.entries[key]
+	if !found {
+		return nil, missing
+	}
+	entry := element.Value.(*Entry)
+	now := cache.now()
+	state := fresh
+	if entry.Expired(now) {
+		switch age := now.Sub(entry.expires()); {
+		case age < cache.revalidate:
+			state = revalidate
+		case age < cache.maxStale:
+			state = stale
+		default:
+			cache.remove(element)
+			return nil, missing
+		}
+	}
+	// a result with less users than requested means that there are no more
+	// users available for the location, so it can serve any larger count
+	users := entry.Ranking.Users
+	exhausted := len(users) < entry.Count
+	if count > entry.Count && !exhausted {
+		return nil, missing
+	}
+	cache.lru.MoveToFront(element)
+	ranking := first(entry.Ranking, count)
+	ranking.Cached = true
+	ranking.Stale = state != fresh
+	return ranking, state
+}
+
+// (private) first returns a copy of the ranking with up to its first `count`
+// users
+func first(ranking *model.Ranking, count int) *model.Ranking {
+	n := util.Min(len(ranking.Users), count)
+	result := *ranking
+	result.Users = ranking.Users[:n:n]
+	return &result
+}
+
+// (private) store saves a result in the cache, evicting the least recently
+// used entries when full
+func (cache *Cache) store(key string, count int, ranking *model.Ranking) {
+	defer cache.flush()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	retain := util.MaxDuration(cache.revalidate, cache.maxStale)
+	entry := &Entry{key, count, ranking, cache.now(), cache.ttl, retain}
+	cache.add(entry)
+	if cache.backing != nil {
+		cache.pending = append(cache.pending, change{entry: *entry})
+	}
+}
+
+// (private) add inserts an entry as the most recently used, evicting the
+// least recently used entries when full. Must be called with the mutex held
+func (cache *Cache) add(entry *Entry) {
+	if element, found := cache.entries[entry.Key]; found {
+		element.Value = entry
+		cache.lru.MoveToFront(element)
+		return
+	}
+	cache.entries[entry.Key] = cache.lru.PushFront(entry)
+	for cache.lru.Len() > cache.maxEntries {
+		cache.remove(cache.lru.Back())
+	}
+}
+
+// (private) remove deletes an element from the cache. Must be called with
+// the mutex held
+func (cache *Cache) remove(element *list.Element) {
+	key := element.Value.(*Entry).Key
+	cache.lru.Remove(element)
+	delete(cache.entries, key)
+	cache.persistDelete(key)
+}
+
+// (private) persistDelete queues the removal of an entry from the store, if
+// any. Must be called with the mutex held
+func (cache *Cache) persistDelete(key string) {
+	if cache.backing != nil {
+		cache.pending = append(cache.pending, change{entry: Entry{Key: key}, deleted: true})
+	}
+}
+
+// (private) flush writes the pending changes to the store, unless another
+// goroutine is already writing them. Changes are written one goroutine at a
+// time, so that they are applied in order. Must be called without the mutex
+// held
+func (cache *Cache) flush() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.flushing {
+		// the changes are written by the goroutine that is flushing
+		return
+	}
+	cache.flushing = true
+	for len(cache.pending) > 0 {
+		changes, backing := cache.pending, cache.backing
+		cache.pending = nil
+		cache.mutex.Unlock()
+		for _, change := range changes {
+			if change.deleted {
+				if err := backing.Delete(change.entry.Key); err != nil {
+					slog.Error("Failed to delete persisted cache entry", "key", change.entry.Key, "error", err)
+				}
+			} else if err := backing.Put(change.entry); err != nil {
+				slog.Error("Failed to persist cache entry", "key", change.entry.Key, "error", err)
+			}
+		}
+		cache.mutex.Lock()
+	}
+	cache.flushing = false
+}
@@ -0,0 +1,486 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/metrics"
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// Counter helper to count calls to the TopContributorGetter interface
+type Counter struct {
+	Available  int
+	Incomplete bool
+	Error      error
+	Calls      int
+}
+
+func (counter *Counter) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	counter.Calls++
+	if counter.Error != nil {
+		return nil, counter.Error
+	}
+	n := util.Min(query.Count, counter.Available)
+	users := make([]model.User, n)
+	for i := 0; i < n; i++ {
+		users[i] = model.User{ID: int64(i), Username: fmt.Sprintf("%s_%d", query.Location, i)}
+	}
+	return &model.Ranking{Users: users, TotalCount: counter.Available, Incomplete: counter.Incomplete}, nil
+}
+
+// Clock helper to control the passing of time
+type Clock struct {
+	current time.Time
+}
+
+func (clock *Clock) now() time.Time {
+	return clock.current
+}
+
+func newCache(counter *Counter, ttl time.Duration, maxEntries int) (*Cache, *Clock) {
+	clock := &Clock{time.Unix(1500000000, 0)}
+	cache := New(counter, ttl, maxEntries)
+	cache.now = clock.now
+	return cache, clock
+}
+
+func get(t *testing.T, cache *Cache, location string, count int, expected int) {
+	ranking, err := cache.GetTopContributors(context.Background(), model.Query{Location: location, Count: count})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranking.Users) != expected {
+		t.Fatalf("%s/%d: expected %d users, got %d", location, count, expected, len(ranking.Users))
+	}
+}
+
+func TestCacheHit(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, _ := newCache(counter, time.Minute, 10)
+
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, " barcelona ", 50, 50)
+	get(t, cache, "BARCELONA", 50, 50)
+	if counter.Calls != 1 {
+		t.Fatalf("one query expected, got %d", counter.Calls)
+	}
+	get(t, cache, "Sao  Paulo", 50, 50)
+	get(t, cache, "sao paulo", 50, 50)
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheProfileQueries(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, _ := newCache(counter, time.Minute, 10)
+
+	query := model.Query{Location: "Barcelona", Count: 50}
+	profile := model.Query{Location: "Barcelona", Count: 50, Profile: true}
+	for i := 0; i < 2; i++ {
+		for _, q := range []model.Query{query, profile} {
+			if _, err := cache.GetTopContributors(context.Background(), q); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheSortOrder(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, _ := newCache(counter, time.Minute, 10)
+
+	queries := []model.Query{
+		{Location: "Barcelona", Count: 50},
+		// same as the defaults
+		{Location: "Barcelona", Count: 50, Sort: model.SortRepositories, Order: model.OrderDesc},
+		{Location: "Barcelona", Count: 50, Sort: model.SortFollowers},
+		{Location: "Barcelona", Count: 50, Sort: model.SortFollowers, Order: model.OrderAsc},
+	}
+	for _, query := range queries {
+		if _, err := cache.GetTopContributors(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counter.Calls != 3 {
+		t.Fatalf("three queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheQualifiers(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, _ := newCache(counter, time.Minute, 10)
+
+	hundred, thousand := 100, 1000
+	queries := []model.Query{
+		{Location: "Berlin", Count: 50},
+		{Location: "Berlin", Count: 50, Qualifiers: model.Qualifiers{Language: "go"}},
+		// language is case insensitive
+		{Location: "Berlin", Count: 50, Qualifiers: model.Qualifiers{Language: "Go"}},
+		{Location: "Berlin", Count: 50, Qualifiers: model.Qualifiers{
+			Followers: model.IntRange{Min: &hundred}}},
+		{Location: "Berlin", Count: 50, Qualifiers: model.Qualifiers{
+			Followers: model.IntRange{Min: &thousand}}},
+		{Location: "Berlin", Count: 50, Qualifiers: model.Qualifiers{
+			Repos: model.IntRange{Min: &hundred}}},
+	}
+	for _, query := range queries {
+		if _, err := cache.GetTopContributors(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counter.Calls != 5 {
+		t.Fatalf("five queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheLargerCount(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, _ := newCache(counter, time.Minute, 10)
+
+	get(t, cache, "Barcelona", 150, 150)
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Barcelona", 100, 100)
+	if counter.Calls != 1 {
+		t.Fatalf("one query expected, got %d", counter.Calls)
+	}
+	get(t, cache, "Barcelona", 200, 200)
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheExhausted(t *testing.T) {
+	counter := &Counter{Available: 30}
+	cache, _ := newCache(counter, time.Minute, 10)
+
+	get(t, cache, "Barcelona", 50, 30)
+	get(t, cache, "Barcelona", 150, 30)
+	get(t, cache, "Barcelona", 10, 10)
+	if counter.Calls != 1 {
+		t.Fatalf("one query expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheExpiration(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 10)
+
+	get(t, cache, "Barcelona", 50, 50)
+	clock.current = clock.current.Add(59 * time.Second)
+	get(t, cache, "Barcelona", 50, 50)
+	if counter.Calls != 1 {
+		t.Fatalf("one query expected, got %d", counter.Calls)
+	}
+	clock.current = clock.current.Add(time.Second)
+	get(t, cache, "Barcelona", 50, 50)
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, _ := newCache(counter, time.Minute, 2)
+
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Madrid", 50, 50)
+	// Barcelona becomes the most recently used
+	get(t, cache, "Barcelona", 50, 50)
+	// evicts Madrid
+	get(t, cache, "Valencia", 50, 50)
+	if cache.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", cache.Len())
+	}
+	if counter.Calls != 3 {
+		t.Fatalf("three queries expected, got %d", counter.Calls)
+	}
+	get(t, cache, "Barcelona", 50, 50)
+	if counter.Calls != 3 {
+		t.Fatalf("three queries expected, got %d", counter.Calls)
+	}
+	get(t, cache, "Madrid", 50, 50)
+	if counter.Calls != 4 {
+		t.Fatalf("four queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheErrorNotCached(t *testing.T) {
+	counter := &Counter{Available: 500, Error: util.NewError("error")}
+	cache, _ := newCache(counter, time.Minute, 10)
+
+	if _, err := cache.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err == nil {
+		t.Fatal("failure expected")
+	}
+	counter.Error = nil
+	get(t, cache, "Barcelona", 50, 50)
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("expected 1 entry, got %d", cache.Len())
+	}
+}
+
+func TestCacheIncompleteNotCached(t *testing.T) {
+	counter := &Counter{Available: 500, Incomplete: true}
+	cache, _ := newCache(counter, time.Minute, 10)
+
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Barcelona", 50, 50)
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+	counter.Incomplete = false
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Barcelona", 10, 10)
+	if counter.Calls != 3 {
+		t.Fatalf("three queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheKeepsTotalCount(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, _ := newCache(counter, time.Minute, 10)
+
+	get(t, cache, "Barcelona", 100, 100)
+	ranking, err := cache.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ranking.TotalCount != 500 || len(ranking.Users) != 10 || !ranking.Cached {
+		t.Fatalf("unexpected ranking: total %d, %d users", ranking.TotalCount, len(ranking.Users))
+	}
+}
+
+func getRanking(t *testing.T, cache *Cache, location string) *model.Ranking {
+	ranking, err := cache.GetTopContributors(context.Background(), model.Query{Location: location, Count: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ranking
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 10)
+	cache.SetStalePolicy(30*time.Second, 0)
+
+	getRanking(t, cache, "Barcelona")
+	clock.current = clock.current.Add(80 * time.Second)
+	if ranking := getRanking(t, cache, "Barcelona"); !ranking.Stale || !ranking.Cached {
+		t.Fatal("expected a stale result")
+	}
+	cache.refreshes.Wait()
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+	if ranking := getRanking(t, cache, "Barcelona"); ranking.Stale {
+		t.Fatal("expected a refreshed result")
+	}
+
+	// beyond the revalidation window the query is synchronous
+	clock.current = clock.current.Add(91 * time.Second)
+	if ranking := getRanking(t, cache, "Barcelona"); ranking.Stale || ranking.Cached {
+		t.Fatal("expected a new result")
+	}
+	cache.refreshes.Wait()
+	if counter.Calls != 3 {
+		t.Fatalf("three queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheRefreshKeepsCount(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 10)
+	cache.SetStalePolicy(time.Minute, 0)
+
+	get(t, cache, "Barcelona", 200, 200)
+	clock.current = clock.current.Add(70 * time.Second)
+	get(t, cache, "Barcelona", 50, 50)
+	cache.refreshes.Wait()
+	get(t, cache, "Barcelona", 200, 200)
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheStaleKeepsCount(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 10)
+	cache.SetStalePolicy(0, time.Hour)
+
+	get(t, cache, "Barcelona", 150, 150)
+	clock.current = clock.current.Add(2 * time.Minute)
+	// the stale entry is replaced by a result for its count
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Barcelona", 150, 150)
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+
+	// and so is a fresh one when refreshed
+	ranking, err := cache.Refresh(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranking.Users) != 50 {
+		t.Fatalf("expected 50 users, got %d", len(ranking.Users))
+	}
+	get(t, cache, "Barcelona", 150, 150)
+	if counter.Calls != 3 {
+		t.Fatalf("three queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheClose(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 10)
+	cache.SetStalePolicy(time.Minute, 0)
+
+	getRanking(t, cache, "Barcelona")
+	getRanking(t, cache, "Madrid")
+	clock.current = clock.current.Add(70 * time.Second)
+	// the refresh in progress is stored before Close returns
+	getRanking(t, cache, "Barcelona")
+	cache.Close()
+	if counter.Calls != 3 {
+		t.Fatalf("three queries expected, got %d", counter.Calls)
+	}
+	if ranking := getRanking(t, cache, "Barcelona"); ranking.Stale {
+		t.Fatal("expected a refreshed result")
+	}
+	// no more refreshes are started once closed
+	if ranking := getRanking(t, cache, "Madrid"); !ranking.Stale {
+		t.Fatal("expected a stale result")
+	}
+	cache.refreshes.Wait()
+	if counter.Calls != 3 {
+		t.Fatalf("three queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheFailedRefresh(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 10)
+	cache.SetStalePolicy(time.Minute, 0)
+
+	getRanking(t, cache, "Barcelona")
+	clock.current = clock.current.Add(70 * time.Second)
+	counter.Error = util.NewError("error")
+	getRanking(t, cache, "Barcelona")
+	cache.refreshes.Wait()
+	// the stale entry is kept
+	if ranking := getRanking(t, cache, "Barcelona"); !ranking.Stale {
+		t.Fatal("expected a stale result")
+	}
+}
+
+func TestCacheStaleOnError(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 10)
+	cache.SetStalePolicy(0, time.Hour)
+
+	getRanking(t, cache, "Barcelona")
+	clock.current = clock.current.Add(2 * time.Minute)
+	counter.Error = util.NewErrorKind(util.Unavailable, "down")
+	if ranking := getRanking(t, cache, "Barcelona"); !ranking.Stale {
+		t.Fatal("expected a stale result")
+	}
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+
+	// a successful query replaces the stale entry
+	counter.Error = nil
+	if ranking := getRanking(t, cache, "Barcelona"); ranking.Stale || ranking.Cached {
+		t.Fatal("expected a new result")
+	}
+
+	// entries older than the max staleness are not served
+	clock.current = clock.current.Add(time.Hour + time.Minute)
+	counter.Error = util.NewErrorKind(util.Unavailable, "down")
+	if _, err := cache.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err == nil {
+		t.Fatal("failure expected")
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("expected no entries, got %d", cache.Len())
+	}
+}
+
+func TestCacheRefresh(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 10)
+
+	get(t, cache, "Barcelona", 50, 50)
+	clock.current = clock.current.Add(50 * time.Second)
+	ranking, err := cache.Refresh(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ranking.Cached || counter.Calls != 2 {
+		t.Fatalf("expected a query, got cached=%v calls=%d", ranking.Cached, counter.Calls)
+	}
+	// the refreshed entry expires a full TTL after the refresh
+	clock.current = clock.current.Add(50 * time.Second)
+	get(t, cache, "Barcelona", 50, 50)
+	if counter.Calls != 2 {
+		t.Fatalf("two queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 10)
+	cache.SetStalePolicy(0, time.Hour)
+
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Barcelona", 10, 10)
+	get(t, cache, "Madrid", 50, 50)
+	// stale results served on error are hits
+	clock.current = clock.current.Add(2 * time.Minute)
+	counter.Error = util.NewErrorKind(util.Unavailable, "down")
+	get(t, cache, "Barcelona", 50, 50)
+	if _, err := cache.GetTopContributors(context.Background(), model.Query{Location: "Paris", Count: 50}); err == nil {
+		t.Fatal("failure expected")
+	}
+	if stats := cache.Stats(); stats != (Stats{Hits: 3, Misses: 3}) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, _ := newCache(counter, time.Minute, 10)
+	registry := metrics.NewRegistry()
+	cache.RegisterMetrics(registry)
+
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Madrid", 50, 50)
+
+	var buffer bytes.Buffer
+	if err := registry.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, series := range []string{
+		"ghas_cache_hits_total 1",
+		"ghas_cache_misses_total 2",
+		"ghas_cache_entries 2",
+	} {
+		if !strings.Contains(buffer.String(), series+"\n") {
+			t.Fatalf("missing series %s in:\n%s", series, buffer.String())
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adriansr/github-api-service/util"
+)
+
+const (
+	// name of the file that stores the entries in the data directory
+	diskStoreFile = "cache.log"
+	// compaction runs when the number of obsolete records exceeds the
+	// number of live ones by this amount
+	compactionSlack = 100
+)
+
+// DiskStore is a Store that keeps the entries in an append-only file under
+// a data directory. Every change adds a record to the file, and obsolete
+// records are discarded by compacting it, which happens when the store is
+// opened and whenever obsolete records dominate the file. Each record is a
+// line with a checksum followed by the JSON representation of the entry, so
+// that corrupt or truncated records are detected and skipped
+type DiskStore struct {
+	path string
+
+	// protects all fields below
+	mutex sync.Mutex
+	file  *os.File
+	// keys with a live record in the file
+	live map[string]bool
+	// total number of records in the file
+	records int
+	// entries read when opened, until loaded
+	loaded []Entry
+
+	// source of time, replaceable for testing
+	now func() time.Time
+}
+
+// (private) record is a change stored in the file
+type record struct {
+	Entry
+	// set for records that delete the entry with the key
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// OpenDiskStore opens the store in the given directory, creating it if
+// necessary. Discarded, deleted and corrupt entries are dropped
+func OpenDiskStore(dir string) (*DiskStore, error) {
+	return openDiskStore(dir, time.Now)
+}
+
+// (private) openDiskStore opens a store that uses the given source of time
+// to discard expired entries
+func openDiskStore(dir string, now func() time.Time) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, util.WrapError("failed to create cache directory", err)
+	}
+	store := &DiskStore{path: filepath.Join(dir, diskStoreFile), now: now}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	entries, err := store.compact()
+	if err != nil {
+		return nil, err
+	}
+	store.loaded = entries
+	return store, nil
+}
+
+// Load returns the entries found when the store was opened
+func (store *DiskStore) Load() ([]Entry, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	entries := store.loaded
+	store.loaded = nil
+	return entries, nil
+}
+
+// Put stores an entry, replacing any other with the same key
+func (store *DiskStore) Put(entry Entry) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.append(record{Entry: entry}); err != nil {
+		return err
+	}
+	store.live[entry.Key] = true
+	return store.maybeCompact()
+}
+
+// Delete removes the entry with the given key, if any
+func (store *DiskStore) Delete(key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if !store.live[key] {
+		return nil
+	}
+	if err := store.append(record{Entry: Entry{Key: key}, Deleted: true}); err != nil {
+		return err
+	}
+	delete(store.live, key)
+	return store.maybeCompact()
+}
+
+// Close closes the underlying file
+func (store *DiskStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.file == nil {
+		return nil
+	}
+	err := store.file.Close()
+	store.file = nil
+	return err
+}
+
+// (private) append writes a record at the end of the file. Must be called
+// with the mutex held
+func (store *DiskStore) append(rec record) error {
+	if store.file == nil {
+		return util.NewError("cache store is closed")
+	}
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return util.WrapError("failed to encode cache entry", err)
+	}
+	if _, err := store.file.Write(line); err != nil {
+		return util.WrapError("failed to write cache entry", err)
+	}
+	store.records++
+	return nil
+}
+
+// (private) maybeCompact compacts the file when most of its records are
+// obsolete. Must be called with the mutex held
+func (store *DiskStore) maybeCompact() error {
+	if store.records-len(store.live) <= len(store.live)+compactionSlack {
+		return nil
+	}
+	_, err := store.compact()
+	return err
+}
+
+// (private) compact rewrites the file keeping only the latest record of
+// each entry that is neither deleted nor expired, and returns those
+// entries. The new file replaces the old one atomically. Must be called
+// with the mutex held
+func (store *DiskStore) compact() ([]Entry, error) {
+	entries, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+	temp := store.path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, util.WrapError("failed to compact cache", err)
+	}
+	writer := bufio.NewWriter(file)
+	for _, entry := range entries {
+		line, err := encodeRecord(record{Entry: entry})
+		if err == nil {
+			_, err = writer.Write(line)
+		}
+		if err != nil {
+			file.Close()
+			return nil, util.WrapError("failed to compact cache", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return nil, util.WrapError("failed to compact cache", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, util.WrapError("failed to compact cache", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, util.WrapError("failed to compact cache", err)
+	}
+	if store.file != nil {
+		store.file.Close()
+		store.file = nil
+	}
+	if err := os.Rename(temp, store.path); err != nil {
+		return nil, util.WrapError("failed to compact cache", err)
+	}
+	if store.file, err = os.OpenFile(store.path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return nil, util.WrapError("failed to open cache file", err)
+	}
+	store.live = make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		store.live[entry.Key] = true
+	}
+	store.records = len(entries)
+	return entries, nil
+}
+
+// (private) read returns the live entries in the file, in the order they
+// were stored. Corrupt records are skipped
+func (store *DiskStore) read() ([]Entry, error) {
+	file, err := os.Open(store.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, util.WrapError("failed to open cache file", err)
+	}
+	defer file.Close()
+
+	latest := make(map[string]int)
+	var records []record
+	corrupt := 0
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a last line without newline is an interrupted write
+			if len(line) > 0 {
+				corrupt++
+			}
+			break
+		}
+		if err != nil {
+			return nil, util.WrapError("failed to read cache file", err)
+		}
+		rec, ok := decodeRecord(line)
+		if !ok {
+			corrupt++
+			continue
+		}
+		latest[rec.Key] = len(records)
+		records = append(records, rec)
+	}
+	if corrupt > 0 {
+		slog.Warn("Discarded corrupt cache entries", "count", corrupt, "path", store.path)
+	}
+
+	now := store.now()
+	var entries []Entry
+	for i, rec := range records {
+		if latest[rec.Key] != i || rec.Deleted || rec.Ranking == nil || rec.Discarded(now) {
+			continue
+		}
+		entries = append(entries, rec.Entry)
+	}
+	return entries, nil
+}
+
+// (private) encodeRecord returns the line that represents a record:
+// the hexadecimal CRC-32 of the JSON document, a space and the document
+func encodeRecord(rec record) ([]byte, error) {
+	document, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(document), document)), nil
+}
+
+// (private) decodeRecord parses a line produced by encodeRecord, returning
+// false if it's corrupt
+func decodeRecord(line []byte) (record, bool) {
+	var rec record
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if len(line) < 10 || line[8] != ' ' {
+		return rec, false
+	}
+	var checksum uint32
+	if _, err := fmt.Sscanf(string(line[:8]), "%08x", &checksum); err != nil {
+		return rec, false
+	}
+	document := line[9:]
+	if crc32.ChecksumIEEE(document) != checksum {
+		return rec, false
+	}
+	if err := json.Unmarshal(document, &rec); err != nil || len(rec.Key) == 0 {
+		return rec, false
+	}
+	return rec, true
+}
@@ -0,0 +1,256 @@
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func openStore(t *testing.T, dir string, clock *Clock) *DiskStore {
+	store, err := openDiskStore(dir, clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func loadKeys(t *testing.T, store *DiskStore) []string {
+	entries, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	return keys
+}
+
+func testEntry(key string, fetched time.Time, ttl time.Duration) Entry {
+	ranking := &model.Ranking{Users: []model.User{{ID: 1, Username: key}}, TotalCount: 1}
+	return Entry{Key: key, Count: 50, Ranking: ranking, FetchedAt: fetched, TTL: ttl}
+}
+
+func TestDiskStoreRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	clock := &Clock{time.Unix(1500000000, 0)}
+
+	store := openStore(t, dir, clock)
+	if keys := loadKeys(t, store); len(keys) != 0 {
+		t.Fatalf("unexpected entries: %v", keys)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := store.Put(testEntry(key, clock.current, time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	// replaces the previous record
+	updated := testEntry("a", clock.current.Add(time.Minute), 2*time.Hour)
+	store.Put(updated)
+	store.Close()
+
+	store = openStore(t, dir, clock)
+	defer store.Close()
+	entries, _ := store.Load()
+	if len(entries) != 2 || entries[0].Key != "c" || entries[1].Key != "a" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	a := entries[1]
+	if !a.FetchedAt.Equal(updated.FetchedAt) || a.TTL != 2*time.Hour || a.Count != 50 ||
+		len(a.Ranking.Users) != 1 || a.Ranking.Users[0].Username != "a" {
+		t.Fatalf("unexpected entry: %+v", a)
+	}
+}
+
+func TestDiskStoreCompaction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	clock := &Clock{time.Unix(1500000000, 0)}
+
+	store := openStore(t, dir, clock)
+	store.Put(testEntry("short", clock.current, time.Minute))
+	store.Put(testEntry("long", clock.current, time.Hour))
+	for i := 0; i < 10; i++ {
+		store.Put(testEntry("updated", clock.current, time.Hour))
+	}
+	store.Close()
+
+	// expired entries and obsolete records are discarded on startup
+	clock.current = clock.current.Add(time.Minute)
+	store = openStore(t, dir, clock)
+	store.Close()
+	content, _ := ioutil.ReadFile(filepath.Join(dir, diskStoreFile))
+	if lines := bytes.Count(content, []byte("\n")); lines != 2 {
+		t.Fatalf("expected 2 records after compaction, got %d", lines)
+	}
+}
+
+func TestDiskStoreRuntimeCompaction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	clock := &Clock{time.Unix(1500000000, 0)}
+
+	store := openStore(t, dir, clock)
+	defer store.Close()
+	for i := 0; i < 3*compactionSlack; i++ {
+		if err := store.Put(testEntry("key", clock.current, time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.records > compactionSlack+2 {
+		t.Fatalf("file not compacted, %d records", store.records)
+	}
+}
+
+func TestDiskStoreCorruption(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	clock := &Clock{time.Unix(1500000000, 0)}
+
+	store := openStore(t, dir, clock)
+	store.Put(testEntry("a", clock.current, time.Hour))
+	store.Put(testEntry("b", clock.current, time.Hour))
+	store.Put(testEntry("c", clock.current, time.Hour))
+	store.Close()
+
+	path := filepath.Join(dir, diskStoreFile)
+	content, _ := ioutil.ReadFile(path)
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	// flip a byte in the record of "b"
+	lines[1][20] ^= 0xff
+	corrupted := bytes.Join([][]byte{
+		lines[0],
+		[]byte("garbage\n"),
+		lines[1],
+		[]byte("00000000 {}\n"),
+		lines[2],
+		// interrupted write
+		lines[0][:30],
+	}, nil)
+	if err := ioutil.WriteFile(path, corrupted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openStore(t, dir, clock)
+	defer store.Close()
+	keys := loadKeys(t, store)
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Fatalf("unexpected entries: %v", keys)
+	}
+	// the store remains usable
+	if err := store.Put(testEntry("d", clock.current, time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachePersistence(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 10)
+	store := openStore(t, dir, clock)
+	if err := cache.Persist(store); err != nil {
+		t.Fatal(err)
+	}
+	get(t, cache, "Barcelona", 100, 100)
+	get(t, cache, "Madrid", 50, 50)
+	clock.current = clock.current.Add(30 * time.Second)
+	get(t, cache, "Valencia", 50, 50)
+	store.Close()
+
+	// a restarted service reuses the entries until they expire
+	clock.current = clock.current.Add(40 * time.Second)
+	restarted, _ := newCache(counter, time.Minute, 10)
+	restarted.now = clock.now
+	store = openStore(t, dir, clock)
+	defer store.Close()
+	if err := restarted.Persist(store); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.Len() != 1 {
+		t.Fatalf("expected 1 entry, got %d", restarted.Len())
+	}
+	get(t, restarted, "Valencia", 50, 50)
+	if counter.Calls != 3 {
+		t.Fatalf("three queries expected, got %d", counter.Calls)
+	}
+	get(t, restarted, "Barcelona", 50, 50)
+	if counter.Calls != 4 {
+		t.Fatalf("four queries expected, got %d", counter.Calls)
+	}
+}
+
+func TestCachePersistenceEviction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	counter := &Counter{Available: 500}
+	cache, clock := newCache(counter, time.Minute, 2)
+	store := openStore(t, dir, clock)
+	defer store.Close()
+	cache.Persist(store)
+	get(t, cache, "Barcelona", 50, 50)
+	get(t, cache, "Madrid", 50, 50)
+	// evicts Barcelona
+	get(t, cache, "Valencia", 50, 50)
+	if len(store.live) != 2 || store.live[model.Query{Location: "Barcelona"}.Key()] {
+		t.Fatalf("unexpected persisted entries: %v", store.live)
+	}
+}
+
+// BlockingStore helper whose writes block until released
+type BlockingStore struct {
+	Writing chan string
+	Release chan struct{}
+}
+
+func (store *BlockingStore) Load() ([]Entry, error) {
+	return nil, nil
+}
+
+func (store *BlockingStore) Put(entry Entry) error {
+	store.Writing <- entry.Key
+	<-store.Release
+	return nil
+}
+
+func (store *BlockingStore) Delete(key string) error {
+	return nil
+}
+
+func TestCachePersistenceDoesNotBlockQueries(t *testing.T) {
+	counter := &Counter{Available: 500}
+	cache, _ := newCache(counter, time.Minute, 10)
+	get(t, cache, "Barcelona", 50, 50)
+	store := &BlockingStore{make(chan string), make(chan struct{})}
+	cache.Persist(store)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		get(t, cache, "Madrid", 50, 50)
+	}()
+	<-store.Writing
+	// cached results are served while the store is being written
+	get(t, cache, "Barcelona", 50, 50)
+	close(store.Release)
+	<-done
+}
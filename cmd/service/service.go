@@ -2,58 +2,313 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/adriansr/github-api-service/cache"
+	"github.com/adriansr/github-api-service/coalesce"
 	"github.com/adriansr/github-api-service/config"
+	"github.com/adriansr/github-api-service/deepscan"
 	"github.com/adriansr/github-api-service/githubapi"
+	"github.com/adriansr/github-api-service/logging"
+	"github.com/adriansr/github-api-service/metrics"
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/pool"
+	"github.com/adriansr/github-api-service/scheduler"
 	"github.com/adriansr/github-api-service/server"
+	"github.com/adriansr/github-api-service/snapshot"
 )
 
 const (
+	// configuration file used when -config is not passed, if present
 	configFilePath = "config.json"
+	// time to wait for the requests in flight when terminating, unless
+	// configured
+	defaultDrainTimeout = 30 * time.Second
 )
 
+// version of the service, set at build time with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
+// newAuthenticator returns the authenticator for the configured credentials,
+// or nil if no credentials are configured
+func newAuthenticator(config *config.Config) (githubapi.Authenticator, error) {
+	credentials := config.Credentials
+	if credentials.AppID != 0 {
+		return githubapi.NewAppAuth(
+			credentials.AppID,
+			credentials.InstallationID,
+			credentials.PrivateKeyFile,
+			config.Client.ApiUrl,
+			config.Client.RequestTimeout.Duration)
+	}
+	if len(credentials.Token) > 0 {
+		return githubapi.NewTokenAuth(credentials.Token), nil
+	}
+	return nil, nil
+}
+
+// fatal logs an error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// newWatches converts the configured watches for the scheduler
+func newWatches(watches []config.WatchConfig) []scheduler.Watch {
+	result := make([]scheduler.Watch, len(watches))
+	for i, watch := range watches {
+		result[i] = scheduler.Watch{
+			Query: model.Query{
+				Location: watch.City,
+				Count:    watch.Count,
+				Sort:     watch.Sort,
+				Order:    watch.Order,
+			},
+			Interval: watch.Interval.Duration,
+		}
+	}
+	return result
+}
+
+// loadConfig builds the configuration from the defaults, the configuration
+// file, the environment and the command-line flags, in that order. The flag
+// set gets the flags for the configuration added before parsing the
+// arguments
+func loadConfig(flags *flag.FlagSet, args []string) (*config.Config, error) {
+	var overrides config.Overrides
+	path := flags.String("config", "",
+		"path of the configuration file (default "+configFilePath+", if present)")
+	overrides.Register(flags)
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return nil, errors.New("unexpected argument: " + flags.Arg(0))
+	}
+
+	file := *path
+	if len(file) == 0 {
+		if _, err := os.Stat(configFilePath); err == nil {
+			file = configFilePath
+		}
+	}
+	return config.Load(config.Sources{
+		File:    file,
+		Environ: os.Environ(),
+		Flags:   overrides,
+	})
+}
+
+// configCommand runs the `config` subcommands and returns the exit code.
+// `config check` validates the configuration without starting the service,
+// printing every problem found
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: service config check [flags]")
+		return 2
+	}
+	flags := flag.NewFlagSet("service config check", flag.ExitOnError)
+	if _, err := loadConfig(flags, args[1:]); err != nil {
+		var invalid *config.ValidationError
+		if !errors.As(err, &invalid) {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, problem := range invalid.Problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		return 1
+	}
+	fmt.Println("Configuration OK")
+	return 0
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+
 	// load configuration
-	config, err := config.LoadFile(configFilePath)
+	flags := flag.NewFlagSet("service", flag.ExitOnError)
+	dump := flags.Bool("dump-config", false,
+		"print the effective configuration, with secrets redacted, and exit")
+	config, err := loadConfig(flags, os.Args[1:])
+	if err != nil {
+		fatal("unable to load configuration", err)
+	}
+	if *dump {
+		if err := config.Dump(os.Stdout); err != nil {
+			fatal("unable to dump configuration", err)
+		}
+		return
+	}
+
+	// log structured records from now on
+	logger, err := logging.New(os.Stderr, config.Log.Level, config.Log.Format)
 	if err != nil {
-		log.Fatal(err)
+		fatal("unable to setup logging", err)
 	}
+	slog.SetDefault(logger)
 
 	// create a client to GitHub API
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		fatal("unable to setup authentication", err)
+	}
 	client, err := githubapi.NewClient(
-		config.Credentials.Username,
-		config.Credentials.Password,
+		auth,
 		config.Client.ApiUrl,
 		config.Client.RequestTimeout.Duration)
 	if err != nil {
-		log.Fatal("unable to start client: ", err)
+		fatal("unable to start client", err)
+	}
+	policy := githubapi.DefaultRateLimitPolicy
+	if config.RateLimit.MaxWait.Duration > 0 {
+		policy.MaxWait = config.RateLimit.MaxWait.Duration
+	}
+	if config.RateLimit.MaxRetries > 0 {
+		policy.MaxRetries = config.RateLimit.MaxRetries
+	}
+	if config.RateLimit.Backoff.Duration > 0 {
+		policy.Backoff = config.RateLimit.Backoff.Duration
+	}
+	client.SetRateLimitPolicy(policy)
+	if config.Client.ProfileConcurrency > 0 {
+		client.SetProfileConcurrency(config.Client.ProfileConcurrency)
+	}
+	if config.Client.IncompleteRetries > 0 {
+		client.SetIncompleteRetries(config.Client.IncompleteRetries)
+	}
+	// deep scans query GitHub directly, so they leave part of the quota for
+	// the queries that go through the pool
+	client.SetDeepScanReserve(config.Scheduler.Reserve, config.DeepScan.ProfileReserve)
+
+	// metrics exposed by the server
+	registry := metrics.NewRegistry()
+	client.RegisterMetrics(registry)
+
+	// availability of GitHub API, to report readiness
+	health := githubapi.NewHealth(config.Server.ReadinessThreshold.Duration)
+	client.AddObserver(health)
+
+	// bound the number of concurrent queries to GitHub
+	var getter model.TopContributorGetter = client
+	if config.Pool.Workers > 0 {
+		workers := pool.New(client, client, config.Pool.Workers, config.Pool.QueueSize)
+		defer workers.Close()
+		getter = workers
+	}
+
+	// record the history of fetched rankings. Below the coalescing, so that
+	// queries that share a fetch save a single snapshot
+	var snapshots *snapshot.Store
+	if config.Snapshots.MaxPerCity > 0 {
+		snapshots, err = snapshot.NewStore(config.Snapshots.DataDir, config.Snapshots.MaxPerCity)
+		if err != nil {
+			fatal("unable to open snapshots", err)
+		}
+		recorder := snapshot.NewRecorder(getter, snapshots)
+		// rankings fetched again while cached, e.g. to serve more pages,
+		// add little to the history
+		recorder.SetMinInterval(config.Cache.TTL.Duration)
+		getter = recorder
+	}
+
+	// share the result of identical concurrent queries
+	group := coalesce.New(getter)
+	group.RegisterMetrics(registry)
+	getter = group
+
+	// cache results to avoid querying GitHub repeatedly for the same location
+	refresh := getter.GetTopContributors
+	var results *cache.Cache
+	if config.Cache.TTL.Duration > 0 && config.Cache.MaxEntries > 0 {
+		results = cache.New(getter, config.Cache.TTL.Duration, config.Cache.MaxEntries)
+		results.SetStalePolicy(config.Cache.StaleWhileRevalidate.Duration, config.Cache.MaxStale.Duration)
+		if len(config.Cache.DataDir) > 0 {
+			store, err := cache.OpenDiskStore(config.Cache.DataDir)
+			if err != nil {
+				fatal("unable to open cache store", err)
+			}
+			defer store.Close()
+			if err := results.Persist(store); err != nil {
+				fatal("unable to restore cache", err)
+			}
+		}
+		// finish background refreshes before closing the store
+		defer results.Close()
+		results.RegisterMetrics(registry)
+		getter = results
+		refresh = results.Refresh
 	}
 
 	// create our HTTP API server
-	server, err := server.New(config.Server.ListenAddress, client)
+	probes := server.Probes{Version: version, Upstream: health, Cache: results}
+	server, err := server.New(config.Server.ListenAddress, getter)
 	if err != nil {
-		log.Fatal("unable to create server: ", err)
+		fatal("unable to create server", err)
+	}
+
+	server.EnableMetrics(registry)
+	server.SetProbes(probes)
+
+	if snapshots != nil {
+		server.EnableSnapshots(snapshots)
 	}
 
-	// capture SIGINT to support graceful termination with CTRL+C
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	// refresh the watched locations in the background
+	if len(config.Scheduler.Watches) > 0 {
+		watches, err := scheduler.New(refresh, client,
+			config.Scheduler.Reserve, config.Scheduler.Jitter,
+			newWatches(config.Scheduler.Watches))
+		if err != nil {
+			fatal("unable to create scheduler", err)
+		}
+		watches.Start()
+		defer watches.Close()
+		server.EnableWatches(watches)
+	}
+
+	// run deep scans in the background
+	if config.DeepScan.Concurrency > 0 {
+		scans := deepscan.New(client, config.DeepScan.Concurrency, config.DeepScan.MaxJobs)
+		defer scans.Close()
+		server.EnableDeepScans(scans)
+	}
+
+	// capture SIGINT (CTRL+C), SIGTERM (sent by orchestrators) and SIGHUP
+	// to support graceful termination
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	// start server in a goroutine
+	failed := make(chan error, 1)
 	go func() {
-		if err := server.Start(); err != nil {
-			log.Print("unable to start server: ", err)
-			c <- os.Interrupt
-		}
+		failed <- server.Start()
 	}()
 
 	// wait for termination (signal or server failure)
-	<-c
+	select {
+	case sig := <-signals:
+		slog.Info("Terminating", "signal", sig.String())
+	case err := <-failed:
+		slog.Error("unable to start server", "error", err)
+	}
 
-	// terminate
-	server.Stop()
-	log.Print("Terminated")
+	// terminate, draining the requests in flight. Background workers are
+	// closed afterwards by the deferred calls
+	timeout := config.Server.DrainTimeout.Duration
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	if err := server.Shutdown(config.Server.DrainDelay.Duration, timeout); err != nil {
+		slog.Error("Shutdown failed", "error", err)
+	}
+	slog.Info("Terminated")
 }
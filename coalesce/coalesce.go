@@ -0,0 +1,135 @@
+// Package coalesce implements duplicate suppression for a
+// TopContributorGetter, so that identical concurrent queries result in a
+// single query to GitHub API
+package coalesce
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/adriansr/github-api-service/metrics"
+	"github.com/adriansr/github-api-service/model"
+)
+
+// Group is a TopContributorGetter that shares the result of an in-flight
+// query with any other identical query received before it completes. The
+// returned rankings are shared between callers and must not be modified.
+type Group struct {
+	getter model.TopContributorGetter
+
+	// protects calls
+	mutex sync.Mutex
+	// in-flight calls by key
+	calls map[string]*call
+
+	// counters, accessed atomically
+	queries      uint64
+	deduplicated uint64
+}
+
+// Stats contains the counters of a Group
+type Stats struct {
+	// Queries is the total number of queries received
+	Queries uint64
+	// Deduplicated is the number of queries that were answered by sharing
+	// the result of an identical in-flight query
+	Deduplicated uint64
+}
+
+// (private) call represents an in-flight query
+type call struct {
+	// closed when the query completes
+	done    chan struct{}
+	ranking *model.Ranking
+	err     error
+
+	// cancels the upstream query
+	cancel context.CancelFunc
+	// number of callers waiting for the result, protected by Group.mutex
+	waiters int
+}
+
+// New returns a Group that forwards queries to the given getter
+func New(getter model.TopContributorGetter) *Group {
+	return &Group{
+		getter: getter,
+		calls:  make(map[string]*call),
+	}
+}
+
+// GetTopContributors forwards the query to the underlying getter, unless an
+// identical query is already in progress, in which case its result is
+// awaited instead. The upstream query is only cancelled when all the callers
+// waiting for it have cancelled their contexts
+func (group *Group) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	key := fmt.Sprintf("%s/%d", query.Key(), query.Count)
+
+	group.mutex.Lock()
+	atomic.AddUint64(&group.queries, 1)
+	current, found := group.calls[key]
+	if found {
+		atomic.AddUint64(&group.deduplicated, 1)
+	} else {
+		// the upstream query must outlive the context of the caller that
+		// started it, as other callers might be waiting for it
+		upstream, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		current = &call{done: make(chan struct{}), cancel: cancel}
+		group.calls[key] = current
+		go group.run(upstream, key, current, query)
+	}
+	current.waiters++
+	group.mutex.Unlock()
+
+	select {
+	case <-current.done:
+		return current.ranking, current.err
+	case <-ctx.Done():
+		group.mutex.Lock()
+		current.waiters--
+		if current.waiters == 0 {
+			// identical queries received from now on start a new call, as
+			// this one is cancelled
+			current.cancel()
+			delete(group.calls, key)
+		}
+		group.mutex.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns the current value of the counters
+func (group *Group) Stats() Stats {
+	return Stats{
+		Queries:      atomic.LoadUint64(&group.queries),
+		Deduplicated: atomic.LoadUint64(&group.deduplicated),
+	}
+}
+
+// RegisterMetrics registers the number of queries received and deduplicated
+// in the given registry
+func (group *Group) RegisterMetrics(registry *metrics.Registry) {
+	registry.NewCounterFunc("ghas_coalesce_queries_total",
+		"Queries received by the coalescing layer.",
+		func() float64 { return float64(group.Stats().Queries) })
+	registry.NewCounterFunc("ghas_coalesce_deduplicated_total",
+		"Queries answered by sharing the result of an identical query in flight.",
+		func() float64 { return float64(group.Stats().Deduplicated) })
+}
+
+// (private) run performs the upstream query for a call
+func (group *Group) run(ctx context.Context, key string, current *call, query model.Query) {
+	ranking, err := group.getter.GetTopContributors(ctx, query)
+
+	group.mutex.Lock()
+	// the call might have been replaced after being cancelled
+	if group.calls[key] == current {
+		delete(group.calls, key)
+	}
+	group.mutex.Unlock()
+
+	current.ranking, current.err = ranking, err
+	current.cancel()
+	close(current.done)
+}
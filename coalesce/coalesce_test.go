@@ -0,0 +1,264 @@
+package coalesce
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/metrics"
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// Gate helper that blocks every query until released
+type Gate struct {
+	Error     error
+	Calls     int32
+	Release   chan struct{}
+	Cancelled chan struct{}
+}
+
+func newGate(err error) *Gate {
+	return &Gate{Error: err, Release: make(chan struct{}), Cancelled: make(chan struct{}, 10)}
+}
+
+func (gate *Gate) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	atomic.AddInt32(&gate.Calls, 1)
+	select {
+	case <-gate.Release:
+	case <-ctx.Done():
+		gate.Cancelled <- struct{}{}
+		return nil, ctx.Err()
+	}
+	if gate.Error != nil {
+		return nil, gate.Error
+	}
+	return &model.Ranking{Users: []model.User{{ID: 1, Username: query.Location}}, TotalCount: 1}, nil
+}
+
+// (private) awaitQueries waits until the group has received n queries
+func awaitQueries(group *Group, n uint64) {
+	for group.Stats().Queries < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	const callers = 50
+	gate := newGate(nil)
+	group := New(gate)
+
+	var wg sync.WaitGroup
+	results := make(chan *model.Ranking, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ranking, err := group.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 100})
+			if err != nil {
+				t.Error(err)
+			}
+			results <- ranking
+		}()
+	}
+	awaitQueries(group, callers)
+	close(gate.Release)
+	wg.Wait()
+	close(results)
+
+	for ranking := range results {
+		if ranking == nil || len(ranking.Users) != 1 || ranking.Users[0].Username != "Barcelona" {
+			t.Fatalf("unexpected result: %v", ranking)
+		}
+	}
+	if gate.Calls != 1 {
+		t.Fatalf("one upstream query expected, got %d", gate.Calls)
+	}
+	stats := group.Stats()
+	if stats.Queries != callers || stats.Deduplicated != callers-1 {
+		t.Fatalf("wrong stats: %+v", stats)
+	}
+}
+
+func TestCoalesceNormalized(t *testing.T) {
+	gate := newGate(nil)
+	group := New(gate)
+
+	var wg sync.WaitGroup
+	for _, query := range []struct {
+		location string
+		count    int
+	}{{"Barcelona", 100}, {"barcelona ", 100}, {"BARCELONA", 100}, {"Barcelona", 50}} {
+		wg.Add(1)
+		go func(location string, count int) {
+			defer wg.Done()
+			group.GetTopContributors(context.Background(), model.Query{Location: location, Count: count})
+		}(query.location, query.count)
+	}
+	awaitQueries(group, 4)
+	close(gate.Release)
+	wg.Wait()
+	// different counts are different queries
+	if gate.Calls != 2 {
+		t.Fatalf("two upstream queries expected, got %d", gate.Calls)
+	}
+	if group.Stats().Deduplicated != 2 {
+		t.Fatalf("wrong stats: %+v", group.Stats())
+	}
+}
+
+func TestCoalesceError(t *testing.T) {
+	const callers = 5
+	gate := newGate(util.NewError("failed"))
+	group := New(gate)
+
+	var wg sync.WaitGroup
+	var failures int32
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := group.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 100}); err == gate.Error {
+				atomic.AddInt32(&failures, 1)
+			}
+		}()
+	}
+	awaitQueries(group, callers)
+	close(gate.Release)
+	wg.Wait()
+	if failures != callers {
+		t.Fatalf("all callers should fail, got %d failures", failures)
+	}
+	if gate.Calls != 1 {
+		t.Fatalf("one upstream query expected, got %d", gate.Calls)
+	}
+}
+
+func TestCoalesceSequential(t *testing.T) {
+	gate := newGate(nil)
+	close(gate.Release)
+	group := New(gate)
+
+	for i := 0; i < 3; i++ {
+		if _, err := group.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// completed queries are not shared
+	if gate.Calls != 3 {
+		t.Fatalf("three upstream queries expected, got %d", gate.Calls)
+	}
+	if group.Stats().Deduplicated != 0 {
+		t.Fatalf("wrong stats: %+v", group.Stats())
+	}
+}
+
+func TestCoalesceCancellation(t *testing.T) {
+	gate := newGate(nil)
+	group := New(gate)
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	results := make(chan error, 2)
+	go func() {
+		_, err := group.GetTopContributors(first, model.Query{Location: "Barcelona", Count: 100})
+		results <- err
+	}()
+	awaitQueries(group, 1)
+	go func() {
+		_, err := group.GetTopContributors(second, model.Query{Location: "Barcelona", Count: 100})
+		results <- err
+	}()
+	awaitQueries(group, 2)
+
+	// the upstream query continues while someone is waiting for it
+	cancelFirst()
+	if err := <-results; err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	select {
+	case <-gate.Cancelled:
+		t.Fatal("upstream query cancelled while still awaited")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancelSecond()
+	if err := <-results; err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	select {
+	case <-gate.Cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream query not cancelled")
+	}
+}
+
+func TestCoalesceAfterCancellation(t *testing.T) {
+	gate := newGate(nil)
+	group := New(gate)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan error, 2)
+	go func() {
+		_, err := group.GetTopContributors(ctx, model.Query{Location: "Barcelona", Count: 100})
+		results <- err
+	}()
+	awaitQueries(group, 1)
+	cancel()
+	if err := <-results; err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+
+	// an identical query doesn't join the cancelled call
+	go func() {
+		_, err := group.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 100})
+		results <- err
+	}()
+	awaitQueries(group, 2)
+	close(gate.Release)
+	if err := <-results; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if calls := atomic.LoadInt32(&gate.Calls); calls != 2 {
+		t.Fatalf("expected 2 upstream queries, got %d", calls)
+	}
+	if stats := group.Stats(); stats.Deduplicated != 0 {
+		t.Fatalf("expected no deduplicated queries, got %d", stats.Deduplicated)
+	}
+}
+
+func TestCoalesceMetrics(t *testing.T) {
+	gate := newGate(nil)
+	group := New(gate)
+	registry := metrics.NewRegistry()
+	group.RegisterMetrics(registry)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			group.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 100})
+		}()
+	}
+	awaitQueries(group, 3)
+	close(gate.Release)
+	wg.Wait()
+
+	var buffer bytes.Buffer
+	if err := registry.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, series := range []string{
+		"ghas_coalesce_queries_total 3",
+		"ghas_coalesce_deduplicated_total 2",
+	} {
+		if !strings.Contains(buffer.String(), series+"\n") {
+			t.Fatalf("missing series %s in:\n%s", series, buffer.String())
+		}
+	}
+}
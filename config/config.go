@@ -1,9 +1,8 @@
-// Package config contains a basic implementation of reading configuration
-// from a json file
+// Package config reads the configuration from a json file, environment
+// variables and command-line flags
 package config
 
 import (
-	"encoding/json"
 	"io/ioutil"
 
 	"github.com/adriansr/github-api-service/util"
@@ -13,26 +12,128 @@ type Config struct {
 	Credentials GitHubCredentials `json:"github_credentials"`
 	Client      HTTPClientConfig  `json:"client"`
 	Server      HTTPServerConfig  `json:"server"`
+	Cache       CacheConfig       `json:"cache"`
+	RateLimit   RateLimitConfig   `json:"rate_limit"`
+	Pool        PoolConfig        `json:"pool"`
+	DeepScan    DeepScanConfig    `json:"deep_scan"`
+	Snapshots   SnapshotsConfig   `json:"snapshots"`
+	Scheduler   SchedulerConfig   `json:"scheduler"`
+	Log         LogConfig         `json:"log"`
 }
 
+// GitHubCredentials selects how to authenticate to GitHub API, either with
+// a personal access token or as a GitHub App installation. Requests are
+// anonymous when neither is configured
 type GitHubCredentials struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Token          string `json:"token"`
+	AppID          int64  `json:"app_id"`
+	InstallationID int64  `json:"installation_id"`
+	PrivateKeyFile string `json:"private_key_file"`
 }
 
 type HTTPClientConfig struct {
 	RequestTimeout Duration `json:"timeout"`
 	ApiUrl         string   `json:"api_url"`
+	// maximum number of user profiles fetched in parallel
+	ProfileConcurrency int `json:"profile_concurrency"`
+	// times a page of search results is requested again when GitHub
+	// reports it as incomplete
+	IncompleteRetries int `json:"incomplete_retries"`
 }
 
 type HTTPServerConfig struct {
 	ListenAddress string `json:"listen"`
+	// time GitHub API can be failing or rate limited before the server
+	// reports it's not ready. Zero keeps the default
+	ReadinessThreshold Duration `json:"readiness_threshold"`
+	// time the server keeps serving requests after reporting it's not
+	// ready, before shutting down. Zero shuts down right away
+	DrainDelay Duration `json:"drain_delay"`
+	// time to wait for the requests in flight when shutting down, before
+	// aborting them. Zero keeps the default
+	DrainTimeout Duration `json:"drain_timeout"`
+}
+
+// CacheConfig controls the caching of results. A zero TTL or
+// MaxEntries disables the cache
+type CacheConfig struct {
+	TTL        Duration `json:"ttl"`
+	MaxEntries int      `json:"max_entries"`
+	// directory where the cache is persisted, kept in memory only if empty
+	DataDir string `json:"data_dir"`
+	// time after expiring during which entries are served while refreshed
+	// in the background
+	StaleWhileRevalidate Duration `json:"stale_while_revalidate"`
+	// time after expiring during which entries are served if GitHub fails
+	MaxStale Duration `json:"max_stale"`
+}
+
+// RateLimitConfig controls how GitHub API rate limits are handled. Zero
+// values keep the defaults
+type RateLimitConfig struct {
+	MaxWait    Duration `json:"max_wait"`
+	MaxRetries int      `json:"max_retries"`
+	Backoff    Duration `json:"backoff"`
+}
+
+// PoolConfig controls the pool of workers that perform queries to GitHub
+// API. Zero workers disables the pool
+type PoolConfig struct {
+	Workers   int `json:"workers"`
+	QueueSize int `json:"queue_size"`
+}
+
+// DeepScanConfig controls the background jobs that rank all the users in a
+// location. Zero concurrency disables deep scans
+type DeepScanConfig struct {
+	Concurrency int `json:"concurrency"`
+	MaxJobs     int `json:"max_jobs"`
+	// requests of the core quota, used to fetch profiles, that are never
+	// used by deep scans
+	ProfileReserve int `json:"profile_reserve"`
+}
+
+// SnapshotsConfig controls the history of rankings. MaxPerCity is the number
+// of snapshots kept for every query of a city, zero disables snapshots
+type SnapshotsConfig struct {
+	// directory where snapshots are saved, kept in memory only if empty
+	DataDir    string `json:"data_dir"`
+	MaxPerCity int    `json:"max_per_city"`
+}
+
+// SchedulerConfig controls the background refresh of watched locations.
+// No watches disables the scheduler
+type SchedulerConfig struct {
+	// fraction of the interval randomly added or subtracted to every run
+	Jitter float64 `json:"jitter"`
+	// requests of the rate limit quota that are never used by the watches
+	// and deep scans
+	Reserve int           `json:"reserve"`
+	Watches []WatchConfig `json:"watches"`
+}
+
+// WatchConfig describes a location whose ranking is refreshed periodically
+type WatchConfig struct {
+	City     string   `json:"city"`
+	Count    int      `json:"count"`
+	Sort     string   `json:"sort"`
+	Order    string   `json:"order"`
+	Interval Duration `json:"interval"`
+}
+
+// LogConfig controls the logs. Empty values default to the info level and
+// the json format
+type LogConfig struct {
+	// minimum level logged: debug, info, warn or error
+	Level string `json:"level"`
+	// json or text
+	Format string `json:"format"`
 }
 
 func LoadRaw(content []byte) (*Config, error) {
 	var config Config
-	if err := json.Unmarshal(content, &config); err != nil {
-		return nil, util.WrapError("failed to parse configuration", err)
+	if err := decode(content, &config); err != nil {
+		return nil, err
 	}
 	return &config, nil
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestLoadRaw(t *testing.T) {
@@ -31,11 +32,22 @@ func TestLoadRaw(t *testing.T) {
 			name: "Credentials",
 			args: args{[]byte(`{
 					    "github_credentials": {
-        					"username": "some_user",
-        					"password": "some_pass"
+        					"token": "some_token"
 						}
 				}`)},
-			want:    &Config{Credentials: GitHubCredentials{"some_user", "some_pass"}},
+			want:    &Config{Credentials: GitHubCredentials{Token: "some_token"}},
+			wantErr: false,
+		},
+		{
+			name: "GitHub App credentials",
+			args: args{[]byte(`{
+					    "github_credentials": {
+        					"app_id": 1234,
+        					"installation_id": 5678,
+        					"private_key_file": "key.pem"
+						}
+				}`)},
+			want:    &Config{Credentials: GitHubCredentials{"", 1234, 5678, "key.pem"}},
 			wantErr: false,
 		},
 		{
@@ -46,7 +58,7 @@ func TestLoadRaw(t *testing.T) {
 						}
 				}`)},
 			// expect a duration of 1.5s, here in nanos:
-			want:    &Config{Client: HTTPClientConfig{Duration{1500000000}, ""}},
+			want:    &Config{Client: HTTPClientConfig{Duration{1500000000}, "", 0, 0}},
 			wantErr: false,
 		},
 		{
@@ -83,21 +95,78 @@ func TestLoadRaw(t *testing.T) {
 			name: "Full config",
 			args: args{[]byte(`{
 						"github_credentials": {
-        					"username": "user",
-        					"password": "password"
+        					"token": "token"
 						},
 					    "client": {
 							"timeout": "500ms",
-							"api_url": "https://api.github.com"
+							"api_url": "https://api.github.com",
+							"profile_concurrency": 8,
+							"incomplete_retries": 1
 						},
 						"server": {
-							"listen": "1.2.3.4:8080"
+							"listen": "1.2.3.4:8080",
+							"readiness_threshold": "2m",
+							"drain_delay": "5s",
+							"drain_timeout": "20s"
+						},
+						"cache": {
+							"ttl": "10m",
+							"max_entries": 100,
+							"data_dir": "/var/lib/service",
+							"stale_while_revalidate": "1m",
+							"max_stale": "24h"
+						},
+						"rate_limit": {
+							"max_wait": "10s",
+							"max_retries": 3,
+							"backoff": "2s"
+						},
+						"pool": {
+							"workers": 2,
+							"queue_size": 50
+						},
+						"deep_scan": {
+							"concurrency": 1,
+							"max_jobs": 20,
+							"profile_reserve": 100
+						},
+						"snapshots": {
+							"data_dir": "/var/lib/snapshots",
+							"max_per_city": 30
+						},
+						"scheduler": {
+							"jitter": 0.1,
+							"reserve": 5,
+							"watches": [
+								{
+									"city": "Barcelona",
+									"count": 100,
+									"sort": "followers",
+									"order": "desc",
+									"interval": "15m"
+								}
+							]
+						},
+						"log": {
+							"level": "debug",
+							"format": "text"
 						}
 				}`)},
 
-			want: &Config{GitHubCredentials{"user", "password"},
-				HTTPClientConfig{Duration{500000000}, "https://api.github.com"},
-				HTTPServerConfig{"1.2.3.4:8080"}},
+			want: &Config{GitHubCredentials{Token: "token"},
+				HTTPClientConfig{Duration{500000000}, "https://api.github.com", 8, 1},
+				HTTPServerConfig{"1.2.3.4:8080", Duration{2 * time.Minute},
+					Duration{5 * time.Second}, Duration{20 * time.Second}},
+				CacheConfig{Duration{10 * time.Minute}, 100, "/var/lib/service",
+					Duration{time.Minute}, Duration{24 * time.Hour}},
+				RateLimitConfig{Duration{10 * time.Second}, 3, Duration{2 * time.Second}},
+				PoolConfig{2, 50},
+				DeepScanConfig{1, 20, 100},
+				SnapshotsConfig{"/var/lib/snapshots", 30},
+				SchedulerConfig{0.1, 5, []WatchConfig{
+					{"Barcelona", 100, "followers", "desc", Duration{15 * time.Minute}},
+				}},
+				LogConfig{"debug", "text"}},
 			wantErr: false,
 		},
 	}
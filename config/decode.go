@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/adriansr/github-api-service/util"
+)
+
+// (private) durationType is decoded as a single value, not as a section
+var durationType = reflect.TypeOf(Duration{})
+
+// (private) decode parses the contents of a configuration file over the
+// given configuration. Values of the wrong type and unknown keys, likely
+// typos, are reported in a *ValidationError along with their path, while
+// the rest of the settings are still decoded
+func decode(content []byte, config *Config) error {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(content, &document); err != nil {
+		return util.WrapError("failed to parse configuration", err)
+	}
+	var v validator
+	v.decode("", content, reflect.ValueOf(config).Elem())
+	return v.err()
+}
+
+// (private) decode decodes a JSON value into target, adding a problem for
+// every key or value under path that can't be decoded
+func (v *validator) decode(path string, raw json.RawMessage, target reflect.Value) {
+	switch {
+	case target.Kind() == reflect.Struct && target.Type() != durationType:
+		var section map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &section); err != nil {
+			v.check(false, path, "expected an object")
+			return
+		}
+		fields := make(map[string]reflect.Value)
+		for i := 0; i < target.NumField(); i++ {
+			fields[target.Type().Field(i).Tag.Get("json")] = target.Field(i)
+		}
+		keys := make([]string, 0, len(section))
+		for key := range section {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyPath := key
+			if len(path) > 0 {
+				keyPath = path + "." + key
+			}
+			field, found := fields[key]
+			if !found {
+				v.check(false, keyPath, "unknown key")
+				continue
+			}
+			v.decode(keyPath, section[key], field)
+		}
+	case target.Kind() == reflect.Slice:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			v.check(false, path, "expected a list")
+			return
+		}
+		if items == nil {
+			target.Set(reflect.Zero(target.Type()))
+			return
+		}
+		list := reflect.MakeSlice(target.Type(), len(items), len(items))
+		for i, item := range items {
+			v.decode(fmt.Sprintf("%s[%d]", path, i), item, list.Index(i))
+		}
+		target.Set(list)
+	default:
+		if err := json.Unmarshal(raw, target.Addr().Interface()); err != nil {
+			message := err.Error()
+			if _, ok := err.(*json.UnmarshalTypeError); ok {
+				message = "expected " + describe(target.Type())
+			}
+			v.check(false, path, message)
+		}
+	}
+}
+
+// (private) describe names the JSON values expected for a type
+func describe(typ reflect.Type) string {
+	switch typ.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice:
+		return "a list"
+	}
+	return "a " + typ.String()
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/adriansr/github-api-service/util"
@@ -14,14 +15,20 @@ type Duration struct {
 // UnmarshalJSON uses `time.ParseDuration` to parse a json string as a
 // duration
 func (d *Duration) UnmarshalJSON(b []byte) error {
-	if b[0] == '"' {
-		unquoted := string(b[1 : len(b)-1])
-		parsed, err := time.ParseDuration(unquoted)
-		if err != nil {
-			return err
-		}
-		d.Duration = parsed
-		return nil
+	var value string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return util.NewError("expected a duration string")
 	}
-	return util.NewError("expected a string to decode a Duration")
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return util.NewError("invalid duration: " + err.Error())
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// MarshalJSON encodes the duration as a json string that UnmarshalJSON
+// understands
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
 }
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/adriansr/github-api-service/util"
+)
+
+// EnvPrefix is the prefix of the environment variables that override
+// settings, e.g. GHAS_SERVER_LISTEN overrides server.listen
+const EnvPrefix = "GHAS_"
+
+// (private) replaces the secrets of a dumped configuration
+const redacted = "[redacted]"
+
+// Setting is a value for the setting identified by Key, the JSON keys that
+// lead to it joined by dots, e.g. server.listen. Values are parsed like
+// their JSON counterparts, except for strings and durations which are
+// taken verbatim
+type Setting struct {
+	Key   string
+	Value string
+}
+
+// Sources are the layers of configuration applied on top of the defaults,
+// in order: the file, the environment and the command-line flags
+type Sources struct {
+	// path of the configuration file, skipped if empty
+	File string
+	// environment variables as returned by os.Environ. Only the ones with
+	// EnvPrefix are considered
+	Environ []string
+	// settings passed as command-line flags
+	Flags []Setting
+}
+
+// Default returns the configuration for the settings that no source sets,
+// the same as in sample.config.json
+func Default() *Config {
+	return &Config{
+		Client: HTTPClientConfig{
+			RequestTimeout:     Duration{3 * time.Second},
+			ApiUrl:             "https://api.github.com",
+			ProfileConcurrency: 4,
+			IncompleteRetries:  2,
+		},
+		Server: HTTPServerConfig{
+			ListenAddress:      ":8080",
+			ReadinessThreshold: Duration{5 * time.Minute},
+			DrainTimeout:       Duration{30 * time.Second},
+		},
+		Cache: CacheConfig{
+			TTL:                  Duration{10 * time.Minute},
+			MaxEntries:           1000,
+			StaleWhileRevalidate: Duration{time.Minute},
+			MaxStale:             Duration{24 * time.Hour},
+		},
+		RateLimit: RateLimitConfig{
+			MaxWait:    Duration{5 * time.Second},
+			MaxRetries: 2,
+			Backoff:    Duration{time.Second},
+		},
+		Pool: PoolConfig{Workers: 4, QueueSize: 100},
+		// deep scans are opt-in, as they can use a large part of the quota
+		DeepScan: DeepScanConfig{MaxJobs: 100, ProfileReserve: 500},
+		Scheduler: SchedulerConfig{
+			Jitter:  0.1,
+			Reserve: 5,
+			Watches: []WatchConfig{},
+		},
+		Log: LogConfig{Level: "info", Format: "json"},
+	}
+}
+
+// Load returns the configuration that results from applying the sources on
+// top of Default, once validated. Settings that a source doesn't mention are
+// kept. All the problems found in the sources and the resulting
+// configuration are reported at once in a *ValidationError
+func Load(sources Sources) (*Config, error) {
+	var v validator
+	config := Default()
+	if len(sources.File) > 0 {
+		content, err := ioutil.ReadFile(sources.File)
+		if err != nil {
+			return nil, util.WrapError(
+				"failed reading configuration file `"+sources.File+"`", err)
+		}
+		if err := v.add(decode(content, config)); err != nil {
+			return nil, err
+		}
+	}
+	settings := envSettings(sources.Environ)
+	for _, setting := range append(settings, sources.Flags...) {
+		v.add(config.Set(setting.Key, setting.Value))
+	}
+	v.add(config.Validate())
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Keys returns the keys of all the settings, in the order they appear in
+// the configuration file
+func Keys() []string {
+	fields := (&Config{}).fields()
+	keys := make([]string, len(fields))
+	for i, field := range fields {
+		keys[i] = field.key
+	}
+	return keys
+}
+
+// EnvName returns the environment variable that overrides a setting
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Set parses the value of the setting identified by key. Invalid values
+// and unknown keys are reported in a *ValidationError
+func (config *Config) Set(key, value string) error {
+	for _, field := range config.fields() {
+		if field.key != key {
+			continue
+		}
+		var v validator
+		switch target := field.value.Addr().Interface().(type) {
+		case *string:
+			*target = value
+		case *Duration:
+			if parsed, err := time.ParseDuration(value); err != nil {
+				v.check(false, key, "invalid duration: "+err.Error())
+			} else {
+				target.Duration = parsed
+			}
+		default:
+			if !json.Valid([]byte(value)) {
+				v.check(false, key, "expected "+describe(field.value.Type()))
+				break
+			}
+			v.decode(key, []byte(value), field.value)
+		}
+		return v.err()
+	}
+	return &ValidationError{[]Problem{{key, "unknown setting"}}}
+}
+
+// Redacted returns a copy of the configuration with its secrets replaced,
+// so that it can be shown
+func (config *Config) Redacted() *Config {
+	result := *config
+	if len(result.Credentials.Token) > 0 {
+		result.Credentials.Token = redacted
+	}
+	return &result
+}
+
+// Dump writes the configuration with its secrets redacted, in the format of
+// the configuration file
+func (config *Config) Dump(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(config.Redacted())
+}
+
+// Overrides collects the settings passed as command-line flags
+type Overrides []Setting
+
+// Register adds to the flag set a flag for every setting, named after its
+// key, e.g. -server.listen
+func (overrides *Overrides) Register(flags *flag.FlagSet) {
+	for _, key := range Keys() {
+		flags.Var(overrideFlag{key, overrides}, key,
+			"overrides "+key+", also set by "+EnvName(key))
+	}
+}
+
+// (private) overrideFlag is a flag that records its values as settings, so
+// that they are applied after the other sources
+type overrideFlag struct {
+	key       string
+	overrides *Overrides
+}
+
+func (flag overrideFlag) String() string {
+	return ""
+}
+
+func (flag overrideFlag) Set(value string) error {
+	*flag.overrides = append(*flag.overrides, Setting{flag.key, value})
+	return nil
+}
+
+// (private) field is a setting of a configuration
+type field struct {
+	key   string
+	value reflect.Value
+}
+
+// (private) fields returns the settings of the configuration, in the order
+// they are declared. Sections are flattened, while durations and lists are
+// single settings
+func (config *Config) fields() []field {
+	var result []field
+	var walk func(prefix string, section reflect.Value)
+	walk = func(prefix string, section reflect.Value) {
+		for i := 0; i < section.NumField(); i++ {
+			key := prefix + section.Type().Field(i).Tag.Get("json")
+			value := section.Field(i)
+			if value.Kind() == reflect.Struct && value.Type() != reflect.TypeOf(Duration{}) {
+				walk(key+".", value)
+				continue
+			}
+			result = append(result, field{key, value})
+		}
+	}
+	walk("", reflect.ValueOf(config).Elem())
+	return result
+}
+
+// (private) envSettings returns the settings overridden in the environment.
+// Unknown variables with EnvPrefix are logged, as they are likely typos, but
+// they might also be meant for something else
+func envSettings(environ []string) []Setting {
+	keys := make(map[string]string)
+	for _, key := range Keys() {
+		keys[EnvName(key)] = key
+	}
+	var result []Setting
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		key, found := keys[name]
+		if !found {
+			slog.Warn("Ignoring unknown environment variable", "name", name)
+			continue
+		}
+		result = append(result, Setting{key, value})
+	}
+	return result
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultMatchesSample(t *testing.T) {
+	config, err := Load(Sources{File: "../sample.config.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config, Default()) {
+		t.Fatalf("sample configuration %v differs from defaults %v", config, Default())
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	path := writeFile(t, `{
+		"server": {"listen": ":9000", "drain_delay": "5s"},
+		"cache": {"ttl": "1m"},
+		"log": {"level": "debug"}
+	}`)
+	var overrides Overrides
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	overrides.Register(flags)
+	if err := flags.Parse([]string{"-server.listen", ":9002", "-pool.workers=8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Load(Sources{
+		File: path,
+		Environ: []string{
+			"HOME=/root",
+			"GHAS_SERVER_LISTENING=:9003",
+			"GHAS_SERVER_LISTEN=:9001",
+			"GHAS_CACHE_TTL=2m",
+			"GHAS_GITHUB_CREDENTIALS_TOKEN=secret",
+			`GHAS_SCHEDULER_WATCHES=[{"city": "Barcelona", "count": 10, "interval": "1h"}]`,
+		},
+		Flags: overrides,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Default()
+	// flags override the environment, which overrides the file. Unknown
+	// variables are ignored
+	expected.Server.ListenAddress = ":9002"
+	expected.Server.DrainDelay = Duration{5 * time.Second}
+	expected.Cache.TTL = Duration{2 * time.Minute}
+	expected.Log.Level = "debug"
+	expected.Credentials.Token = "secret"
+	expected.Pool.Workers = 8
+	expected.Scheduler.Watches = []WatchConfig{{City: "Barcelona", Count: 10, Interval: Duration{time.Hour}}}
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("got %v, expected %v", config, expected)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources Sources
+		message string
+	}{
+		{"Missing file", Sources{File: "missing.json"}, "failed reading configuration file"},
+		{"Invalid duration", Sources{Environ: []string{"GHAS_CACHE_TTL=10"}}, "cache.ttl"},
+		{"Invalid number", Sources{Flags: []Setting{{"pool.workers", "many"}}}, "pool.workers"},
+		{"Unknown setting", Sources{Flags: []Setting{{"pool", "4"}}}, "unknown setting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.sources)
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("expected an error about %s, got %v", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if name := EnvName("github_credentials.token"); name != "GHAS_GITHUB_CREDENTIALS_TOKEN" {
+		t.Fatalf("unexpected name %s", name)
+	}
+	if keys := Keys(); keys[0] != "github_credentials.token" || keys[len(keys)-1] != "log.format" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+func TestDumpRedactsSecrets(t *testing.T) {
+	config := Default()
+	config.Credentials.Token = "secret"
+	var output bytes.Buffer
+	if err := config.Dump(&output); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), "secret") || !strings.Contains(output.String(), redacted) {
+		t.Fatalf("token not redacted: %s", output.String())
+	}
+	if config.Credentials.Token != "secret" {
+		t.Fatal("the configuration was modified")
+	}
+
+	// the dump can be loaded back, once the token is set
+	path := writeFile(t, output.String())
+	if _, err := Load(Sources{File: path}); err == nil || !strings.Contains(err.Error(), "github_credentials.token") {
+		t.Fatalf("redacted token accepted: %v", err)
+	}
+	loaded, err := Load(Sources{File: path, Environ: []string{"GHAS_GITHUB_CREDENTIALS_TOKEN=secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, config) {
+		t.Fatalf("got %v, expected %v", loaded, config)
+	}
+}
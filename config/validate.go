@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/adriansr/github-api-service/logging"
+	"github.com/adriansr/github-api-service/model"
+)
+
+// Problem is an invalid setting, identified by its JSON path
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (problem Problem) String() string {
+	return problem.Path + ": " + problem.Message
+}
+
+// ValidationError reports all the problems found in a configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (err *ValidationError) Error() string {
+	problems := make([]string, len(err.Problems))
+	for i, problem := range err.Problems {
+		problems[i] = problem.String()
+	}
+	return "invalid configuration: " + strings.Join(problems, "; ")
+}
+
+// Validate checks that the configuration can be used by the service. All
+// the problems found are reported at once in a *ValidationError
+func (config *Config) Validate() error {
+	var v validator
+	v.credentials(config.Credentials)
+	v.check(config.Credentials.Token != redacted, "github_credentials.token",
+		"is redacted, set the actual token")
+
+	client := config.Client
+	v.positive("client.timeout", client.RequestTimeout)
+	v.check(validURL(client.ApiUrl), "client.api_url", "must be an absolute http or https URL")
+	v.nonNegative("client.profile_concurrency", client.ProfileConcurrency)
+	v.nonNegative("client.incomplete_retries", client.IncompleteRetries)
+
+	server := config.Server
+	v.check(validAddress(server.ListenAddress), "server.listen", "must be a host:port address")
+	v.nonNegativeDuration("server.readiness_threshold", server.ReadinessThreshold)
+	v.nonNegativeDuration("server.drain_delay", server.DrainDelay)
+	v.nonNegativeDuration("server.drain_timeout", server.DrainTimeout)
+
+	cache := config.Cache
+	v.nonNegativeDuration("cache.ttl", cache.TTL)
+	v.nonNegative("cache.max_entries", cache.MaxEntries)
+	v.nonNegativeDuration("cache.stale_while_revalidate", cache.StaleWhileRevalidate)
+	v.nonNegativeDuration("cache.max_stale", cache.MaxStale)
+
+	v.nonNegativeDuration("rate_limit.max_wait", config.RateLimit.MaxWait)
+	v.nonNegative("rate_limit.max_retries", config.RateLimit.MaxRetries)
+	v.nonNegativeDuration("rate_limit.backoff", config.RateLimit.Backoff)
+
+	v.nonNegative("pool.workers", config.Pool.Workers)
+	v.nonNegative("pool.queue_size", config.Pool.QueueSize)
+	v.nonNegative("deep_scan.concurrency", config.DeepScan.Concurrency)
+	v.nonNegative("deep_scan.max_jobs", config.DeepScan.MaxJobs)
+	v.nonNegative("deep_scan.profile_reserve", config.DeepScan.ProfileReserve)
+	v.nonNegative("snapshots.max_per_city", config.Snapshots.MaxPerCity)
+
+	scheduler := config.Scheduler
+	v.check(scheduler.Jitter >= 0 && scheduler.Jitter < 1, "scheduler.jitter", "must be in the [0, 1) range")
+	v.nonNegative("scheduler.reserve", scheduler.Reserve)
+	for i, watch := range scheduler.Watches {
+		v.watch(fmt.Sprintf("scheduler.watches[%d]", i), watch)
+	}
+
+	var level slog.Level
+	v.check(len(config.Log.Level) == 0 || level.UnmarshalText([]byte(config.Log.Level)) == nil,
+		"log.level", "must be one of debug, info, warn or error")
+	format := strings.ToLower(config.Log.Format)
+	v.check(len(format) == 0 || format == logging.FormatJSON || format == logging.FormatText,
+		"log.format", "must be one of json or text")
+
+	return v.err()
+}
+
+// (private) validator collects the problems of a configuration
+type validator struct {
+	problems []Problem
+}
+
+// (private) check adds a problem for the setting at path unless ok
+func (v *validator) check(ok bool, path, message string) {
+	if !ok {
+		v.problems = append(v.problems, Problem{path, message})
+	}
+}
+
+func (v *validator) positive(path string, value Duration) {
+	v.check(value.Duration > 0, path, "must be > 0")
+}
+
+func (v *validator) nonNegativeDuration(path string, value Duration) {
+	v.check(value.Duration >= 0, path, "must be >= 0")
+}
+
+func (v *validator) nonNegative(path string, value int) {
+	v.check(value >= 0, path, "must be >= 0")
+}
+
+// (private) credentials checks that a GitHub App is fully configured, if
+// any of its settings is set
+func (v *validator) credentials(credentials GitHubCredentials) {
+	if credentials.AppID == 0 && credentials.InstallationID == 0 && len(credentials.PrivateKeyFile) == 0 {
+		return
+	}
+	const app = " to authenticate as a GitHub App"
+	v.check(credentials.AppID > 0, "github_credentials.app_id", "must be > 0"+app)
+	v.check(credentials.InstallationID > 0, "github_credentials.installation_id", "must be > 0"+app)
+	v.check(len(credentials.PrivateKeyFile) > 0, "github_credentials.private_key_file", "must be set"+app)
+	v.check(len(credentials.Token) == 0, "github_credentials.token", "must be empty"+app)
+}
+
+// (private) watch checks that a watch can be run by the scheduler
+func (v *validator) watch(path string, watch WatchConfig) {
+	v.check(len(model.NormalizeLocation(watch.City)) > 0, path+".city", "must be set")
+	v.check(watch.Count >= 1 && watch.Count <= model.MaxContributors, path+".count",
+		fmt.Sprintf("must be between 1 and %d", model.MaxContributors))
+	v.check(len(watch.Sort) == 0 || model.ValidSort(watch.Sort), path+".sort",
+		fmt.Sprintf("must be one of %s, %s or %s", model.SortFollowers, model.SortRepositories, model.SortJoined))
+	v.check(len(watch.Order) == 0 || model.ValidOrder(watch.Order), path+".order",
+		fmt.Sprintf("must be one of %s or %s", model.OrderDesc, model.OrderAsc))
+	v.positive(path+".interval", watch.Interval)
+}
+
+// (private) add collects the problems of a *ValidationError, returning any
+// other error
+func (v *validator) add(err error) error {
+	if invalid, ok := err.(*ValidationError); ok {
+		v.problems = append(v.problems, invalid.Problems...)
+		return nil
+	}
+	return err
+}
+
+// (private) err returns the problems found as a *ValidationError, or nil if
+// none
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{v.problems}
+}
+
+// (private) validURL returns if the value is an absolute http or https URL
+func validURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && len(parsed.Host) > 0
+}
+
+// (private) validAddress returns if the value is an address that the server
+// can listen on. The host can be empty to listen on all interfaces
+func validAddress(value string) bool {
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return false
+	}
+	_, err = net.LookupPort("tcp", port)
+	return err == nil
+}
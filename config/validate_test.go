@@ -0,0 +1,174 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func problemPaths(t *testing.T, err error) []string {
+	invalid, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	paths := make([]string, len(invalid.Problems))
+	for i, problem := range invalid.Problems {
+		paths[i] = problem.Path
+	}
+	return paths
+}
+
+func TestValidateDefault(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		paths  []string
+	}{
+		{
+			name: "Client",
+			change: func(config *Config) {
+				config.Client.RequestTimeout = Duration{}
+				config.Client.ApiUrl = ""
+				config.Client.ProfileConcurrency = -1
+			},
+			paths: []string{"client.timeout", "client.api_url", "client.profile_concurrency"},
+		},
+		{
+			name: "Relative API URL",
+			change: func(config *Config) {
+				config.Client.ApiUrl = "api.github.com"
+			},
+			paths: []string{"client.api_url"},
+		},
+		{
+			name: "Listen address",
+			change: func(config *Config) {
+				config.Server.ListenAddress = "8080"
+			},
+			paths: []string{"server.listen"},
+		},
+		{
+			name: "Listen port",
+			change: func(config *Config) {
+				config.Server.ListenAddress = "localhost:99999"
+			},
+			paths: []string{"server.listen"},
+		},
+		{
+			name: "Incomplete GitHub App",
+			change: func(config *Config) {
+				config.Credentials.Token = "token"
+				config.Credentials.AppID = 1234
+			},
+			paths: []string{"github_credentials.installation_id",
+				"github_credentials.private_key_file", "github_credentials.token"},
+		},
+		{
+			name: "Negative values",
+			change: func(config *Config) {
+				config.Cache.TTL = Duration{-1}
+				config.Pool.Workers = -1
+				config.Scheduler.Jitter = 1
+			},
+			paths: []string{"cache.ttl", "pool.workers", "scheduler.jitter"},
+		},
+		{
+			name: "Watches",
+			change: func(config *Config) {
+				config.Scheduler.Watches = []WatchConfig{
+					{City: "Barcelona", Count: 10, Interval: Duration{1}},
+					{City: " ", Count: 0, Sort: "stars", Order: "up"},
+				}
+			},
+			paths: []string{"scheduler.watches[1].city", "scheduler.watches[1].count",
+				"scheduler.watches[1].sort", "scheduler.watches[1].order",
+				"scheduler.watches[1].interval"},
+		},
+		{
+			name: "Logs",
+			change: func(config *Config) {
+				config.Log.Level = "verbose"
+				config.Log.Format = "xml"
+			},
+			paths: []string{"log.level", "log.format"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Default()
+			tt.change(config)
+			if paths := problemPaths(t, config.Validate()); !reflect.DeepEqual(paths, tt.paths) {
+				t.Fatalf("got problems in %v, expected %v", paths, tt.paths)
+			}
+		})
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	_, err := LoadRaw([]byte(`{
+		"server": {"listen": ":8080", "listen_address": ":8080"},
+		"cahce": {},
+		"scheduler": {"watches": [{"city": "Barcelona"}, {"citty": "Madrid"}]}
+	}`))
+	expected := []string{"cahce", "scheduler.watches[1].citty", "server.listen_address"}
+	if paths := problemPaths(t, err); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("got problems in %v, expected %v", paths, expected)
+	}
+}
+
+func TestDecodeProblems(t *testing.T) {
+	_, err := LoadRaw([]byte(`{
+		"client": {"timeout": 3, "api_url": 1, "profile_concurrency": "4"},
+		"server": {"drain_delay": "5"},
+		"pool": [],
+		"scheduler": {"jitter": 0.1, "watches": [{"city": "Barcelona", "count": 1.5}]}
+	}`))
+	invalid, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	expected := []Problem{
+		{"client.api_url", "expected a string"},
+		{"client.profile_concurrency", "expected an integer"},
+		{"client.timeout", "expected a duration string"},
+		{"pool", "expected an object"},
+		{"scheduler.watches[0].count", "expected an integer"},
+		{"server.drain_delay", `invalid duration: time: missing unit in duration "5"`},
+	}
+	if !reflect.DeepEqual(invalid.Problems, expected) {
+		t.Fatalf("got problems %v, expected %v", invalid.Problems, expected)
+	}
+
+	// the valid settings are still decoded and validated
+	path := writeFile(t, `{"client": {"timeout": 3}, "server": {"listen": "localhost"}}`)
+	_, err = Load(Sources{File: path, Flags: []Setting{{"pool.workers", "many"}}})
+	paths := problemPaths(t, err)
+	if expected := []string{"client.timeout", "pool.workers", "server.listen"}; !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("got problems in %v, expected %v", paths, expected)
+	}
+}
+
+func TestLoadReportsAllProblems(t *testing.T) {
+	path := writeFile(t, `{"client": {"timeout": "0s", "retries": 2}}`)
+	_, err := Load(Sources{
+		File:    path,
+		Environ: []string{"GHAS_POOL_WORKERS=four"},
+		Flags:   []Setting{{"server.listen", "localhost"}},
+	})
+	expected := []string{"client.retries", "pool.workers", "client.timeout", "server.listen"}
+	if paths := problemPaths(t, err); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("got problems in %v, expected %v", paths, expected)
+	}
+	if message := err.Error(); message != "invalid configuration: "+
+		"client.retries: unknown key; "+
+		"pool.workers: expected an integer; "+
+		"client.timeout: must be > 0; "+
+		"server.listen: must be a host:port address" {
+		t.Fatalf("unexpected message: %s", message)
+	}
+}
@@ -0,0 +1,217 @@
+// Package deepscan runs deep scans in the background. A deep scan ranks all
+// the users matching a query, which can take a large number of requests to
+// GitHub API, so instead of being answered immediately, queries become jobs
+// whose status and result are checked later
+package deepscan
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/adriansr/github-api-service/logging"
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// Scanner is an interface for a type that ranks all the users matching a
+// query, like githubapi.Client
+type Scanner interface {
+	DeepScan(ctx context.Context, query model.Query) (*model.Ranking, error)
+}
+
+// Status of a Job
+type Status string
+
+// job statuses
+const (
+	// waiting for a free slot to run
+	StatusPending Status = "pending"
+	// in progress
+	StatusRunning Status = "running"
+	// completed successfully, the result is available
+	StatusDone Status = "done"
+	// completed with an error
+	StatusFailed Status = "failed"
+)
+
+// Job is a snapshot of the state of a deep scan
+type Job struct {
+	ID         string         `json:"id"`
+	City       string         `json:"city"`
+	Status     Status         `json:"status"`
+	CreatedAt  time.Time      `json:"created_at"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Result     *model.Ranking `json:"result,omitempty"`
+
+	// (private) query being scanned
+	query model.Query
+}
+
+// Finished returns if the job is done or failed
+func (job *Job) Finished() bool {
+	return job.Status == StatusDone || job.Status == StatusFailed
+}
+
+// Manager runs deep scans in the background, up to a maximum number
+// concurrently. It remembers up to a maximum number of jobs, discarding the
+// oldest finished ones as new jobs are submitted
+type Manager struct {
+	scanner Scanner
+	// limits the number of jobs running concurrently
+	slots   chan struct{}
+	maxJobs int
+
+	mutex  sync.Mutex
+	jobs   map[string]*Job
+	order  []string
+	closed bool
+
+	// cancels running jobs when the manager is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// (private) current time, can be replaced for testing
+	now func() time.Time
+}
+
+// New creates a Manager that runs up to `concurrency` deep scans at a time
+// using the given scanner and remembers up to `maxJobs` jobs
+func New(scanner Scanner, concurrency int, maxJobs int) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		scanner: scanner,
+		slots:   make(chan struct{}, util.Max(concurrency, 1)),
+		maxJobs: util.Max(maxJobs, 1),
+		jobs:    make(map[string]*Job),
+		ctx:     ctx,
+		cancel:  cancel,
+		now:     time.Now,
+	}
+}
+
+// Submit starts a deep scan for the query in the background, returning the
+// new job. If an identical query is already pending or running, its job is
+// returned instead. Fails with util.ErrQueueFull when the maximum number of
+// jobs is reached and none of them is finished
+func (manager *Manager) Submit(query model.Query) (Job, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if manager.closed {
+		return Job{}, util.NewErrorKind(util.Overloaded, "deep scans are closed")
+	}
+	key := query.Key()
+	for _, id := range manager.order {
+		if job := manager.jobs[id]; !job.Finished() && job.query.Key() == key {
+			return *job, nil
+		}
+	}
+	if len(manager.order) >= manager.maxJobs && !manager.evict() {
+		return Job{}, util.ErrQueueFull
+	}
+	id, err := newID()
+	if err != nil {
+		return Job{}, util.WrapError("failed to create job", err)
+	}
+	job := &Job{
+		ID:        id,
+		City:      query.Location,
+		Status:    StatusPending,
+		CreatedAt: manager.now(),
+		query:     query,
+	}
+	manager.jobs[id] = job
+	manager.order = append(manager.order, id)
+	manager.wg.Add(1)
+	go manager.run(job)
+	return *job, nil
+}
+
+// Get returns the job with the given ID, if known
+func (manager *Manager) Get(id string) (Job, bool) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	job, found := manager.jobs[id]
+	if !found {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Close cancels all the jobs in progress and waits for them to terminate
+func (manager *Manager) Close() {
+	manager.mutex.Lock()
+	manager.closed = true
+	manager.mutex.Unlock()
+	manager.cancel()
+	manager.wg.Wait()
+}
+
+// (private) evict discards the oldest finished job. Returns false if all
+// jobs are in progress. Must be called with the mutex held
+func (manager *Manager) evict() bool {
+	for i, id := range manager.order {
+		if manager.jobs[id].Finished() {
+			delete(manager.jobs, id)
+			manager.order = append(manager.order[:i], manager.order[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// (private) run waits for a free slot and performs the deep scan of a job
+func (manager *Manager) run(job *Job) {
+	defer manager.wg.Done()
+	select {
+	case manager.slots <- struct{}{}:
+		defer func() { <-manager.slots }()
+	case <-manager.ctx.Done():
+		manager.finish(job, nil, manager.ctx.Err())
+		return
+	}
+
+	manager.mutex.Lock()
+	started := manager.now()
+	job.Status, job.StartedAt = StatusRunning, &started
+	manager.mutex.Unlock()
+
+	// the requests of the scan are identified by the job ID
+	ctx := logging.WithRequestID(manager.ctx, job.ID)
+	slog.InfoContext(ctx, "Deep scan started", "city", job.City)
+	ranking, err := manager.scanner.DeepScan(ctx, job.query)
+	if err != nil {
+		slog.ErrorContext(ctx, "Deep scan failed", "error", err)
+	} else {
+		slog.InfoContext(ctx, "Deep scan done", "users", len(ranking.Users))
+	}
+	manager.finish(job, ranking, err)
+}
+
+// (private) finish records the outcome of a job
+func (manager *Manager) finish(job *Job, ranking *model.Ranking, err error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	finished := manager.now()
+	job.FinishedAt = &finished
+	if err != nil {
+		job.Status, job.Error = StatusFailed, err.Error()
+		return
+	}
+	job.Status, job.Result = StatusDone, ranking
+}
+
+// (private) newID returns a random job identifier
+func newID() (string, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
@@ -0,0 +1,179 @@
+package deepscan
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// Gate helper that blocks every scan until released
+type Gate struct {
+	Error   error
+	Calls   int32
+	Running int32
+	Release chan struct{}
+}
+
+func newGate(err error) *Gate {
+	return &Gate{Error: err, Release: make(chan struct{})}
+}
+
+func (gate *Gate) DeepScan(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	atomic.AddInt32(&gate.Calls, 1)
+	atomic.AddInt32(&gate.Running, 1)
+	defer atomic.AddInt32(&gate.Running, -1)
+	select {
+	case <-gate.Release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if gate.Error != nil {
+		return nil, gate.Error
+	}
+	return &model.Ranking{Users: []model.User{{ID: 1, Username: query.Location}}, TotalCount: 1}, nil
+}
+
+// (private) await waits until the job reaches the given status
+func await(t *testing.T, manager *Manager, id string, status Status) Job {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ := manager.Get(id); job.Status == status {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	job, _ := manager.Get(id)
+	t.Fatalf("job %s: expected status %s, got %s", id, status, job.Status)
+	return job
+}
+
+func TestJobLifecycle(t *testing.T) {
+	gate := newGate(nil)
+	manager := New(gate, 1, 10)
+	defer manager.Close()
+
+	job, err := manager.Submit(model.Query{Location: "Barcelona"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusPending || job.City != "Barcelona" || len(job.ID) == 0 {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+	await(t, manager, job.ID, StatusRunning)
+	close(gate.Release)
+	job = await(t, manager, job.ID, StatusDone)
+	if job.Result == nil || len(job.Result.Users) != 1 || job.StartedAt == nil || job.FinishedAt == nil {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+	if _, found := manager.Get("unknown"); found {
+		t.Fatal("unknown job found")
+	}
+}
+
+func TestJobFailure(t *testing.T) {
+	gate := newGate(util.NewError("failed"))
+	close(gate.Release)
+	manager := New(gate, 1, 10)
+	defer manager.Close()
+
+	job, err := manager.Submit(model.Query{Location: "Barcelona"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = await(t, manager, job.ID, StatusFailed)
+	if job.Error != "failed" || job.Result != nil {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	gate := newGate(nil)
+	manager := New(gate, 2, 10)
+	defer manager.Close()
+
+	var ids []string
+	for _, city := range []string{"Barcelona", "Madrid", "Valencia"} {
+		job, err := manager.Submit(model.Query{Location: city})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+	for atomic.LoadInt32(&gate.Calls) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if running := atomic.LoadInt32(&gate.Running); running != 2 {
+		t.Fatalf("expected 2 running scans, got %d", running)
+	}
+	close(gate.Release)
+	for _, id := range ids {
+		await(t, manager, id, StatusDone)
+	}
+}
+
+func TestDuplicateQuery(t *testing.T) {
+	gate := newGate(nil)
+	manager := New(gate, 1, 10)
+	defer manager.Close()
+
+	first, _ := manager.Submit(model.Query{Location: "Barcelona"})
+	second, _ := manager.Submit(model.Query{Location: " barcelona"})
+	if first.ID != second.ID {
+		t.Fatal("identical queries should share the job")
+	}
+	close(gate.Release)
+	await(t, manager, first.ID, StatusDone)
+	// finished jobs are not reused
+	third, _ := manager.Submit(model.Query{Location: "Barcelona"})
+	if third.ID == first.ID {
+		t.Fatal("finished job reused")
+	}
+}
+
+func TestMaxJobs(t *testing.T) {
+	gate := newGate(nil)
+	manager := New(gate, 2, 2)
+	defer manager.Close()
+
+	first, _ := manager.Submit(model.Query{Location: "Barcelona"})
+	manager.Submit(model.Query{Location: "Madrid"})
+	if _, err := manager.Submit(model.Query{Location: "Valencia"}); !errors.Is(err, util.Overloaded) {
+		t.Fatalf("expected overloaded, got %v", err)
+	}
+	close(gate.Release)
+	await(t, manager, first.ID, StatusDone)
+	// the oldest finished job is discarded
+	if _, err := manager.Submit(model.Query{Location: "Valencia"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := manager.Get(first.ID); found {
+		t.Fatal("oldest job not discarded")
+	}
+}
+
+func TestCloseCancelsJobs(t *testing.T) {
+	gate := newGate(nil)
+	manager := New(gate, 1, 10)
+
+	first, _ := manager.Submit(model.Query{Location: "Barcelona"})
+	second, _ := manager.Submit(model.Query{Location: "Madrid"})
+	// either of them may take the only slot
+	for atomic.LoadInt32(&gate.Calls) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	manager.Close()
+	for _, id := range []string{first.ID, second.ID} {
+		if job, _ := manager.Get(id); job.Status != StatusFailed {
+			t.Fatalf("job %s not cancelled: %+v", id, job)
+		}
+	}
+	if _, err := manager.Submit(model.Query{Location: "Valencia"}); err == nil {
+		t.Fatal("submit after close should fail")
+	}
+}
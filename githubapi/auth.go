@@ -0,0 +1,201 @@
+package githubapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adriansr/github-api-service/util"
+)
+
+// Authenticator adds credentials to the requests sent to GitHub API
+type Authenticator interface {
+	// Authenticate sets the required headers in the request
+	Authenticate(request *http.Request) error
+}
+
+// TokenAuth authenticates requests with a personal access token
+type TokenAuth struct {
+	Token string
+}
+
+// NewTokenAuth returns an Authenticator that uses the given personal
+// access token
+func NewTokenAuth(token string) *TokenAuth {
+	return &TokenAuth{token}
+}
+
+// Authenticate sets the token in the Authorization header
+func (auth *TokenAuth) Authenticate(request *http.Request) error {
+	request.Header.Set("Authorization", "Bearer "+auth.Token)
+	return nil
+}
+
+// AppAuth authenticates requests as an installation of a GitHub App. An
+// installation token is obtained by presenting a JWT signed with the App's
+// private key, and is renewed automatically before it expires
+type AppAuth struct {
+	appID          int64
+	installationID int64
+	key            *rsa.PrivateKey
+	apiUrl         string
+	httpClient     http.Client
+
+	// protects the token fields
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+
+	// source of time, replaceable for testing
+	now func() time.Time
+}
+
+// (private) representation of the response to an installation token request
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+const (
+	// validity of the JWT used to request installation tokens. GitHub
+	// allows a maximum of 10 minutes
+	jwtLifetime = 9 * time.Minute
+	// the JWT issue time is set in the past to allow for clock drift
+	jwtClockDrift = time.Minute
+	// installation tokens are renewed this long before they expire
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// NewAppAuth returns an Authenticator for the given App installation, using
+// the private key stored in PEM format at `keyFile`
+func NewAppAuth(appID, installationID int64, keyFile, apiUrl string, timeout time.Duration) (*AppAuth, error) {
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, util.WrapError("failed reading private key `"+keyFile+"`", err)
+	}
+	key, err := parsePrivateKey(content)
+	if err != nil {
+		return nil, util.WrapError("failed parsing private key `"+keyFile+"`", err)
+	}
+	return &AppAuth{
+		appID:          appID,
+		installationID: installationID,
+		key:            key,
+		apiUrl:         apiUrl,
+		httpClient:     http.Client{Timeout: timeout},
+		now:            time.Now,
+	}, nil
+}
+
+// Authenticate sets a valid installation token in the Authorization header,
+// requesting a new one if necessary
+func (auth *AppAuth) Authenticate(request *http.Request) error {
+	token, err := auth.currentToken(request.Context())
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// (private) currentToken returns the installation token, renewing it when
+// it's about to expire
+func (auth *AppAuth) currentToken(ctx context.Context) (string, error) {
+	auth.mutex.Lock()
+	defer auth.mutex.Unlock()
+	if len(auth.token) > 0 && auth.now().Add(tokenRefreshMargin).Before(auth.expires) {
+		return auth.token, nil
+	}
+	token, err := auth.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	auth.token, auth.expires = token.Token, token.ExpiresAt
+	return auth.token, nil
+}
+
+// (private) requestToken obtains a new installation token from GitHub API
+func (auth *AppAuth) requestToken(ctx context.Context) (*installationToken, error) {
+	jwt, err := auth.signJWT()
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", auth.apiUrl, auth.installationID)
+	request, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return nil, util.WrapError("failed creating a request object", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+jwt)
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("User-Agent", userAgent)
+	response, err := auth.httpClient.Do(request)
+	if err != nil {
+		return nil, util.WrapError("failed requesting an installation token", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return nil, util.NewError(fmt.Sprintf(
+			"installation token request failed with code %d", response.StatusCode))
+	}
+	var token installationToken
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return nil, util.WrapError("failed decoding installation token", err)
+	}
+	if len(token.Token) == 0 {
+		return nil, util.NewError("empty installation token received")
+	}
+	return &token, nil
+}
+
+// (private) signJWT creates a JSON Web Token identifying the App, signed
+// with its private key using RS256
+func (auth *AppAuth) signJWT() (string, error) {
+	now := auth.now()
+	header := `{"alg":"RS256","typ":"JWT"}`
+	claims := fmt.Sprintf(`{"iat":%d,"exp":%d,"iss":"%s"}`,
+		now.Add(-jwtClockDrift).Unix(),
+		now.Add(jwtLifetime).Unix(),
+		strconv.FormatInt(auth.appID, 10))
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString([]byte(header)) + "." +
+		encoding.EncodeToString([]byte(claims))
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, auth.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", util.WrapError("failed signing JWT", err)
+	}
+	return unsigned + "." + encoding.EncodeToString(signature), nil
+}
+
+// (private) parsePrivateKey decodes an RSA private key in PEM format, either
+// PKCS#1 (as generated by GitHub) or PKCS#8
+func parsePrivateKey(content []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, util.NewError("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, util.NewError("not an RSA private key")
+	}
+	return key, nil
+}
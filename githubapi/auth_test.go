@@ -0,0 +1,190 @@
+package githubapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+// TokenEndpoint fakes the GitHub App installation token endpoint,
+// validating the JWT and issuing a new token on every request
+type TokenEndpoint struct {
+	t              *testing.T
+	key            *rsa.PublicKey
+	appID          string
+	installationID int64
+	validity       time.Duration
+	now            func() time.Time
+	Issued         int
+}
+
+func (endpoint *TokenEndpoint) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	expectedPath := fmt.Sprintf("/app/installations/%d/access_tokens", endpoint.installationID)
+	if request.Method != "POST" || request.URL.Path != expectedPath {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	jwt := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if err := endpoint.verify(jwt); err != nil {
+		endpoint.t.Errorf("invalid JWT: %s", err)
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	endpoint.Issued++
+	body, _ := json.Marshal(installationToken{
+		Token:     fmt.Sprintf("token_%d", endpoint.Issued),
+		ExpiresAt: endpoint.now().Add(endpoint.validity),
+	})
+	writer.WriteHeader(http.StatusCreated)
+	writer.Write(body)
+}
+
+func (endpoint *TokenEndpoint) verify(jwt string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("wrong number of parts: %d", len(parts))
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(endpoint.key, crypto.SHA256, digest[:], signature); err != nil {
+		return err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+		Iss string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return err
+	}
+	now := endpoint.now().Unix()
+	if claims.Iss != endpoint.appID || claims.Iat > now || claims.Exp <= now ||
+		claims.Exp-claims.Iat > int64((10*time.Minute).Seconds()) {
+		return fmt.Errorf("wrong claims: %+v", claims)
+	}
+	return nil
+}
+
+func writeKey(t *testing.T, key *rsa.PrivateKey) string {
+	dir, err := ioutil.TempDir("", "auth_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "key.pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAppAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeKey(t, key)
+	defer os.RemoveAll(filepath.Dir(keyFile))
+
+	now := time.Unix(1500000000, 0)
+	clock := func() time.Time { return now }
+	endpoint := &TokenEndpoint{t: t, key: &key.PublicKey, appID: "1234",
+		installationID: 5678, validity: time.Hour, now: clock}
+	tokenServer := httptest.NewServer(endpoint)
+	defer tokenServer.Close()
+
+	auth, err := NewAppAuth(1234, 5678, keyFile, tokenServer.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.now = clock
+
+	handler := &RequestResponseTester{nil, 500, []byte("bye")}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client, err := NewClient(auth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectToken := func(token string, issued int) {
+		client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+		if got := handler.Request.Header.Get("Authorization"); got != "Bearer "+token {
+			t.Fatalf("Wrong auth: '%s' expected '%s'", got, token)
+		}
+		if endpoint.Issued != issued {
+			t.Fatalf("expected %d tokens issued, got %d", issued, endpoint.Issued)
+		}
+	}
+
+	expectToken("token_1", 1)
+	// token is reused while valid
+	now = now.Add(30 * time.Minute)
+	expectToken("token_1", 1)
+	// and refreshed when about to expire
+	now = now.Add(26 * time.Minute)
+	expectToken("token_2", 2)
+}
+
+func TestAppAuthFailure(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeKey(t, key)
+	defer os.RemoveAll(filepath.Dir(keyFile))
+
+	tokenServer := httptest.NewServer(&RequestResponseTester{nil, 401, []byte("{}")})
+	defer tokenServer.Close()
+	auth, err := NewAppAuth(1234, 5678, keyFile, tokenServer.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &RequestResponseTester{nil, 200, toJSON(t, makeResponse(50, false, 50))}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client, err := NewClient(auth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err == nil {
+		t.Fatal("failure expected")
+	}
+	if handler.Request != nil {
+		t.Fatal("no request expected")
+	}
+}
+
+func TestAppAuthInvalidKey(t *testing.T) {
+	if _, err := NewAppAuth(1234, 5678, "/non/existing/key.pem", "", timeout); err == nil {
+		t.Fatal("failure expected")
+	}
+	if _, err := parsePrivateKey([]byte("not a key")); err == nil {
+		t.Fatal("failure expected")
+	}
+}
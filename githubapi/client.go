@@ -1,15 +1,20 @@
 package githubapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"io/ioutil"
+	"log/slog"
 
 	"net/url"
+	"strings"
 
+	"github.com/adriansr/github-api-service/logging"
 	"github.com/adriansr/github-api-service/model"
 	"github.com/adriansr/github-api-service/util"
 )
@@ -17,17 +22,54 @@ import (
 // Client encapsulates the fields required to perform queries to the GitHub
 // search API
 type Client struct {
-	username, password string
-	apiUrl             string
+	// authenticator for requests, nil for anonymous access
+	auth   Authenticator
+	apiUrl string
 	// clients are safe for concurrent use
 	httpClient http.Client
+	policy     RateLimitPolicy
+	// quota for the search API
+	limiter *rateLimiter
+	// quota for the rest of the API (core), used to fetch user profiles
+	coreLimiter *rateLimiter
+	// maximum number of profiles fetched in parallel
+	profileConcurrency int
+	// times a search page with incomplete results is requested again
+	incompleteRetries int
+	// requests of the search and core quotas that deep scans leave for other
+	// queries
+	deepScanReserve        int
+	deepScanProfileReserve int
+	// notified of every request
+	observers []Observer
 }
 
+// Observer is implemented by types that monitor the requests to GitHub API.
+// Requests are identified by the rate limit resource they count against,
+// ResourceSearch or ResourceCore
+type Observer interface {
+	// ObserveRequest is called when a response is received, or with a zero
+	// code and the error when the request failed without a response
+	ObserveRequest(resource string, code int, elapsed time.Duration, err error)
+	// ObserveRateLimit is called when a response updates the quota
+	ObserveRateLimit(resource string, status RateLimitStatus)
+}
+
+// GitHub API rate limit resources
+const (
+	ResourceSearch = "search"
+	ResourceCore   = "core"
+)
+
 // (private) representation of a github user as returned by the search API,
 // featuring only the required fields
 type githubUser struct {
-	ID    int64  `json:"id"`
-	Login string `json:"login"`
+	ID        int64   `json:"id"`
+	Login     string  `json:"login"`
+	AvatarURL string  `json:"avatar_url"`
+	HTMLURL   string  `json:"html_url"`
+	Type      string  `json:"type"`
+	Score     float64 `json:"score"`
 }
 
 // (private) representation of a search API response
@@ -42,41 +84,140 @@ const (
 	// as sugested in GitHub docs
 	userAgent = "adriansr/github-api-service"
 	debugBody = false
+	// maximum number of results per page supported by the search API
+	maxPerPage = 100
+	// default number of profiles fetched in parallel
+	defaultProfileConcurrency = 4
+	// default number of retries for pages with incomplete results
+	defaultIncompleteRetries = 2
 )
 
-// NewClient returns a newly created Client to the GitHub API
-func NewClient(username, password, apiUrl string, timeout time.Duration) (*Client, error) {
+// NewClient returns a newly created Client to the GitHub API. A nil
+// Authenticator performs anonymous requests
+func NewClient(auth Authenticator, apiUrl string, timeout time.Duration) (*Client, error) {
 	return &Client{
-		username:   username,
-		password:   password,
+		auth:       auth,
 		apiUrl:     apiUrl,
 		httpClient: http.Client{Timeout: timeout},
+		policy:     DefaultRateLimitPolicy,
+		limiter:    newRateLimiter(ResourceSearch),
+
+		coreLimiter:        newRateLimiter(ResourceCore),
+		profileConcurrency: defaultProfileConcurrency,
+		incompleteRetries:  defaultIncompleteRetries,
 	}, nil
 }
 
-// GetTopContributors queries the GitHub API for the `count` top contributors
-// on the given location.
-func (client *Client) GetTopContributors(location string, count int) ([]model.User, error) {
-	if count != 50 && count != 100 && count != 150 {
-		return nil, util.NewError("count parameter out of range")
+// SetProfileConcurrency changes the maximum number of user profiles that
+// are fetched in parallel. Not safe to call while queries are in progress
+func (client *Client) SetProfileConcurrency(concurrency int) {
+	client.profileConcurrency = util.Max(concurrency, 1)
+}
+
+// SetIncompleteRetries changes how many times a page of search results is
+// requested again when GitHub reports it as incomplete, which happens when
+// the search times out on GitHub's side. Not safe to call while queries are
+// in progress
+func (client *Client) SetIncompleteRetries(retries int) {
+	client.incompleteRetries = util.Max(retries, 0)
+}
+
+// SetDeepScanReserve changes the number of requests of the search quota,
+// and of the core quota used to fetch profiles, that deep scans never use, so
+// that they are left for the queries of the users of the service. Not safe to
+// call while queries are in progress
+func (client *Client) SetDeepScanReserve(searches, profiles int) {
+	client.deepScanReserve = util.Max(searches, 0)
+	client.deepScanProfileReserve = util.Max(profiles, 0)
+}
+
+// SetRateLimitPolicy changes how the client reacts to rate limits. Not safe
+// to call while queries are in progress
+func (client *Client) SetRateLimitPolicy(policy RateLimitPolicy) {
+	client.policy = policy
+}
+
+// AddObserver adds an observer that is notified of every request. Not safe
+// to call while queries are in progress
+func (client *Client) AddObserver(observer Observer) {
+	client.observers = append(client.observers, observer)
+}
+
+// RateLimit returns the last known status of the search API quota
+func (client *Client) RateLimit() RateLimitStatus {
+	return client.limiter.status()
+}
+
+// AwaitQuota blocks until the search API quota allows to perform a request,
+// without consuming it. A RateLimited error is returned if that is not expected
+// to happen within the MaxWait of the client's RateLimitPolicy
+func (client *Client) AwaitQuota(ctx context.Context) error {
+	return client.limiter.wait(ctx, client.policy.MaxWait, 0, false)
+}
+
+// GetTopContributors queries the GitHub API for the top contributors
+// matching the query. When the query requests profiles, they are fetched
+// for every user, with up to `profileConcurrency` requests in parallel.
+// Results that are still incomplete after retrying are returned flagged as
+// Incomplete
+func (client *Client) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	count := query.Count
+	if count < 1 || count > model.MaxContributors {
+		return nil, util.NewErrorKind(util.InvalidQuery, "count parameter out of range")
+	}
+	if err := query.Qualifiers.Validate(); err != nil {
+		return nil, util.WrapErrorKind(util.InvalidQuery, "invalid qualifiers", err)
 	}
 
-	// request up to 100 results, as GitHub search API currently limits to
-	// 100 results per page
-	// TODO: support an arbitrary limit
-	limit := util.Min(count, 100)
-	result, err := client.searchUsers(location, limit, 1)
+	ranking, err := client.search(ctx, query, count, 0)
 	if err != nil {
 		return nil, err
 	}
-	if len(result.Items) < limit || limit == count {
-		return result.users(), nil
+	if query.Profile {
+		if err := client.fetchProfiles(ctx, ranking.Users); err != nil {
+			return nil, err
+		}
 	}
-	result2, err := client.searchUsers(location, 50, 3)
-	if err != nil {
-		return nil, err
+	return ranking, nil
+}
+
+// (private) search performs a paginated search for up to `count` users
+// matching the query, returning them along with the total number of matches.
+// If `stopAbove` is positive and the total number of matches exceeds it, the
+// search stops after the first page. Pages with incomplete results are
+// retried up to `incompleteRetries` times
+func (client *Client) search(ctx context.Context, query model.Query, count, stopAbove int) (*model.Ranking, error) {
+	// GitHub search API currently limits to 100 results per page, so the
+	// results are split into as many pages as necessary to reach `count`
+	perPage := util.Min(count, maxPerPage)
+	pages := (count + perPage - 1) / perPage
+
+	ranking := &model.Ranking{
+		Users:     make([]model.User, 0, count),
+		FetchedAt: client.limiter.now(),
+	}
+	next := client.searchURL(query, perPage)
+	for page := 0; page < pages && len(next) > 0; page++ {
+		result, link, err := client.fetchPage(ctx, next)
+		for retry := 0; err == nil && result.Incomplete && retry < client.incompleteRetries; retry++ {
+			result, link, err = client.fetchPage(ctx, next)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ranking.Users = append(ranking.Users, result.users()...)
+		ranking.TotalCount = result.TotalCount
+		ranking.Incomplete = ranking.Incomplete || result.Incomplete
+		if len(result.Items) == 0 || len(ranking.Users) >= ranking.TotalCount ||
+			(stopAbove > 0 && ranking.TotalCount > stopAbove) {
+			break
+		}
+		next = link
+	}
+	if len(ranking.Users) > count {
+		ranking.Users = ranking.Users[:count]
 	}
-	return append(result.users(), result2.users()...), nil
+	return ranking, nil
 }
 
 // (private) transforms the internal representation of the list of
@@ -86,51 +227,224 @@ func (client *Client) GetTopContributors(location string, count int) ([]model.Us
 func (response *searchResponse) users() []model.User {
 	result := make([]model.User, len(response.Items))
 	for idx, user := range response.Items {
-		result[idx].ID = user.ID
-		result[idx].Username = user.Login
+		result[idx] = model.User{
+			ID:        user.ID,
+			Username:  user.Login,
+			AvatarURL: user.AvatarURL,
+			HTMLURL:   user.HTMLURL,
+			Type:      user.Type,
+			Score:     user.Score,
+		}
 	}
 	return result
 }
 
-// (private) searchUsers perform a user search query against GitHub API
-// filtering by location
-func (client *Client) searchUsers(location string, count int, page int) (*searchResponse, error) {
-	query := fmt.Sprintf("sort=repositories&order=desc&per_page=%d&page=%d&q=location:%s",
-		count, page, url.QueryEscape(location))
-	url := fmt.Sprintf("%s/search/users?%s", client.apiUrl, query)
-	request, err := http.NewRequest("GET", url, nil)
+// (private) searchURL builds the URL for the first page of a user search
+// query against GitHub API
+func (client *Client) searchURL(query model.Query, perPage int) string {
+	sort, order := query.SortOrder()
+	params := fmt.Sprintf("sort=%s&order=%s&per_page=%d&page=1&q=%s",
+		url.QueryEscape(sort), url.QueryEscape(order), perPage,
+		url.QueryEscape(searchQuery(query)))
+	return fmt.Sprintf("%s/search/users?%s", client.apiUrl, params)
+}
+
+// (private) fetchPage performs a single user search request to the given
+// url. Along with the decoded response, it returns the url of the next page
+// of results as advertised by the `Link` header, or an empty string if this
+// is the last page
+func (client *Client) fetchPage(ctx context.Context, url string) (*searchResponse, string, error) {
+	var searchResult searchResponse
+	header, err := client.getJSON(ctx, client.limiter, url, &searchResult)
+	if err != nil {
+		return nil, "", err
+	}
+	next := parseLinks(header.Get("Link"))["next"]
+	return &searchResult, next, nil
+}
+
+// (private) getJSON performs a GET request to the given url and decodes the
+// json response into `result`, returning the response headers. The request
+// is accounted in the given rate limiter. Requests rejected due to rate
+// limits are retried according to the client's RateLimitPolicy
+func (client *Client) getJSON(ctx context.Context, limiter *rateLimiter, url string, result interface{}) (http.Header, error) {
+	maxWait, reserve := client.quotaLimits(ctx, limiter)
+	for attempt := 0; ; attempt++ {
+		if err := limiter.acquire(ctx, maxWait, reserve); err != nil {
+			return nil, err
+		}
+		started := time.Now()
+		response, err := client.get(ctx, url)
+		elapsed := time.Since(started)
+		client.observe(limiter, response, elapsed, err)
+		if err != nil {
+			slog.DebugContext(ctx, "GitHub API request failed", "url", url,
+				"duration_ms", elapsed.Milliseconds(), "error", err)
+			return nil, err
+		}
+		slog.DebugContext(ctx, "GitHub API request", "url", url,
+			"status", response.StatusCode, "duration_ms", elapsed.Milliseconds())
+		limiter.update(response.Header)
+		for _, observer := range client.observers {
+			observer.ObserveRateLimit(limiter.resource, limiter.status())
+		}
+		if !isRateLimited(response) {
+			return response.Header, decodeJSON(response, result)
+		}
+		response.Body.Close()
+		delay, err := client.retryDelay(limiter, response, attempt, maxWait)
+		if err != nil {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "GitHub API rate limit exceeded, retrying",
+			"resource", limiter.resource, "attempt", attempt+1, "delay", delay.String())
+		if err := limiter.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// (private) observe notifies the observers of a request accounted in the
+// given limiter. The response is nil if the request failed with `err`
+func (client *Client) observe(limiter *rateLimiter, response *http.Response, elapsed time.Duration, err error) {
+	code := 0
+	if response != nil {
+		code = response.StatusCode
+	}
+	for _, observer := range client.observers {
+		observer.ObserveRequest(limiter.resource, code, elapsed, err)
+	}
+}
+
+// (private) get sends a GET request to the API, bound to the given context
+func (client *Client) get(ctx context.Context, url string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, util.WrapError("failed creating a request object", err)
 	}
-	if len(client.username) > 0 && len(client.password) > 0 {
-		request.SetBasicAuth(client.username, client.password)
+	if client.auth != nil {
+		if err := client.auth.Authenticate(request); err != nil {
+			return nil, util.WrapError("failed authenticating request", err)
+		}
 	}
 	request.Header.Add("User-Agent", userAgent)
+	// allows to correlate the request with the one that caused it
+	if id := logging.RequestID(ctx); len(id) > 0 {
+		request.Header.Set(logging.RequestIDHeader, id)
+	}
 	response, err := client.httpClient.Do(request)
 	if err != nil {
-		return nil, util.WrapError("failed creating an HTTP client", err)
+		return nil, util.WrapErrorKind(requestErrorKind(err), "GitHub API request failed", err)
+	}
+	return response, nil
+}
+
+// (private) requestErrorKind classifies the error returned by an HTTP
+// request. Requests cancelled by the caller are not considered a failure
+// of GitHub API
+func requestErrorKind(err error) util.Kind {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return util.Internal
+	case util.KindOf(err) == util.Timeout:
+		return util.Timeout
+	default:
+		return util.Unavailable
 	}
+}
+
+// (private) statusError is the cause of a failure due to an unsuccessful
+// response
+type statusError struct {
+	code int
+}
+
+func (err statusError) Error() string {
+	return fmt.Sprintf("HTTP request failed with code %d", err.code)
+}
+
+// (private) statusErrorKind classifies a failed response by its status code
+func statusErrorKind(code int) util.Kind {
+	switch {
+	case code == http.StatusUnprocessableEntity:
+		// GitHub API couldn't process the search query
+		return util.InvalidQuery
+	case code >= 500:
+		return util.Unavailable
+	default:
+		return util.Internal
+	}
+}
+
+// (private) retryDelay returns how long to wait before retrying a request
+// that was rejected due to a rate limit, or a RateLimited error if it must not
+// be retried, including when the delay exceeds `maxWait`
+func (client *Client) retryDelay(limiter *rateLimiter, response *http.Response, attempt int, maxWait time.Duration) (time.Duration, error) {
+	now := limiter.now()
+	delay, found := retryAfter(response.Header, now)
+	if !found {
+		if status := limiter.status(); status.Remaining == 0 {
+			// primary rate limit, wait until the quota is reset
+			delay = status.Reset.Sub(now)
+		} else {
+			// secondary rate limit without a hint, use exponential backoff
+			delay = client.policy.Backoff << uint(attempt)
+		}
+	}
+	if attempt >= client.policy.MaxRetries || delay > maxWait {
+		return 0, util.NewRateLimitError("GitHub API rate limit exceeded",
+			util.MaxDuration(delay, 0))
+	}
+	return delay, nil
+}
+
+// (private) decodeJSON decodes a json API response into `result`
+func decodeJSON(response *http.Response, result interface{}) error {
 	defer response.Body.Close()
 
 	if response.StatusCode != 200 {
-		return nil, util.NewError(fmt.Sprintf("HTTP request failed with code %d",
-			response.StatusCode))
+		return util.WrapErrorKind(statusErrorKind(response.StatusCode),
+			"GitHub API request failed", statusError{response.StatusCode})
 	}
 
-	var searchResult searchResponse
 	if debugBody {
 		body, err := ioutil.ReadAll(response.Body)
 		if err != nil {
-			return nil, util.WrapError("failed reading response body", err)
+			return util.WrapErrorKind(util.Unavailable, "failed reading response body", err)
 		}
 		fmt.Printf("Received body [%d bytes] <<<%s>>>", len(body), body)
-		if err := json.Unmarshal(body, &searchResult); err != nil {
-			return nil, util.WrapError("Failed unmarshalling json response", err)
+		if err := json.Unmarshal(body, result); err != nil {
+			return util.WrapErrorKind(util.Decode, "Failed unmarshalling json response", err)
 		}
 	} else {
-		if err := json.NewDecoder(response.Body).Decode(&searchResult); err != nil {
-			return nil, util.WrapError("failed decoding json response", err)
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+			return util.WrapErrorKind(util.Decode, "failed decoding json response", err)
+		}
+	}
+	return nil
+}
+
+// (private) parseLinks parses the contents of a `Link` header as used by
+// GitHub API for pagination, returning a map from relation to url:
+//
+//	<https://api.github.com/...&page=2>; rel="next", <...>; rel="last"
+func parseLinks(header string) map[string]string {
+	links := make(map[string]string)
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if len(target) < 2 || target[0] != '<' || target[len(target)-1] != '>' {
+			continue
+		}
+		target = target[1 : len(target)-1]
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "rel=") {
+				for _, rel := range strings.Fields(strings.Trim(param[4:], `"`)) {
+					links[rel] = target
+				}
+			}
 		}
 	}
-	return &searchResult, nil
+	return links
 }
@@ -1,22 +1,28 @@
 package githubapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/adriansr/github-api-service/logging"
 	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
 )
 
 const (
-	noUser  = ""
-	noPass  = ""
 	timeout = 3 * time.Second
 )
 
+// anonymous access
+var noAuth Authenticator
+
 type RequestResponseTester struct {
 	Request  *http.Request
 	Code     int
@@ -77,19 +83,19 @@ func TestParsing(t *testing.T) {
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
-	client, err := NewClient(noUser, noPass, server.URL, timeout)
+	client, err := NewClient(noAuth, server.URL, timeout)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	result, err := client.GetTopContributors("Barcelona", 50)
+	ranking, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(result) != 50 {
-		t.Fatalf("result: %v", result)
+	if len(ranking.Users) != 50 {
+		t.Fatalf("result: %v", ranking.Users)
 	}
-	assertEquals(t, response.Items, result)
+	assertEquals(t, response.Items, ranking.Users)
 }
 
 func TestNoAuth(t *testing.T) {
@@ -97,35 +103,35 @@ func TestNoAuth(t *testing.T) {
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
-	client, err := NewClient(noUser, noPass, server.URL, timeout)
+	client, err := NewClient(noAuth, server.URL, timeout)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	client.GetTopContributors("Barcelona", 50)
+	client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
 
-	user, pass, ok := handler.Request.BasicAuth()
-	if user != "" || pass != "" || ok {
-		t.Fatalf("Wrong auth: user:'%s' pass:'%s' valid:%v", user, pass, ok)
+	if auth := handler.Request.Header.Get("Authorization"); auth != "" {
+		t.Fatalf("Unexpected auth: '%s'", auth)
 	}
 }
 
-func TestWithAuth(t *testing.T) {
-	someUser, somePass := "someUser", "somePass"
+func TestWithToken(t *testing.T) {
 	handler := &RequestResponseTester{nil, 500, []byte("bye")}
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
-	client, err := NewClient(someUser, somePass, server.URL, timeout)
+	client, err := NewClient(NewTokenAuth("someToken"), server.URL, timeout)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	client.GetTopContributors("Barcelona", 50)
+	client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
 
-	user, pass, ok := handler.Request.BasicAuth()
-	if user != someUser || pass != somePass || !ok {
-		t.Fatalf("Wrong auth: user:'%s' pass:'%s' valid:%v", user, pass, ok)
+	if auth := handler.Request.Header.Get("Authorization"); auth != "Bearer someToken" {
+		t.Fatalf("Wrong auth: '%s'", auth)
+	}
+	if _, _, ok := handler.Request.BasicAuth(); ok {
+		t.Fatal("Unexpected basic auth")
 	}
 }
 
@@ -134,18 +140,18 @@ func TestEscaping(t *testing.T) {
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
-	client, err := NewClient(noUser, noPass, server.URL, timeout)
+	client, err := NewClient(noAuth, server.URL, timeout)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	city := "Rio de Janeiro"
-	client.GetTopContributors(city, 50)
+	client.GetTopContributors(context.Background(), model.Query{Location: city, Count: 50})
 
 	if handler.Request == nil {
 		t.Fatal("request not sent")
 	}
-	expected := fmt.Sprintf("location:%s", city)
+	expected := fmt.Sprintf(`location:"%s"`, city)
 	query := handler.Request.URL.Query().Get("q")
 	if query != expected {
 		t.Fatalf("unexpected query string: '%s' vs '%s'", query, expected)
@@ -157,12 +163,12 @@ func TestApiError(t *testing.T) {
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
-	client, err := NewClient(noUser, noPass, server.URL, timeout)
+	client, err := NewClient(noAuth, server.URL, timeout)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = client.GetTopContributors("Barcelona", 50)
+	_, err = client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
 	if err == nil {
 		t.Fatal("failure expected")
 	}
@@ -175,17 +181,328 @@ func TestRedirect(t *testing.T) {
 	defer server.Close()
 
 	server2 := httptest.NewServer(RedirectHandler{server.URL})
-	client, err := NewClient(noUser, noPass, server2.URL, timeout)
+	client, err := NewClient(noAuth, server2.URL, timeout)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	result, err := client.GetTopContributors("Barcelona", 50)
+	ranking, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(result) != 50 {
-		t.Fatalf("result: %v", result)
+	if len(ranking.Users) != 50 {
+		t.Fatalf("result: %v", ranking.Users)
+	}
+	assertEquals(t, response.Items, ranking.Users)
+}
+
+// PaginatedTester simulates the search API paginating over `Total` users,
+// advertising the following page in a `Link` header
+type PaginatedTester struct {
+	Total    int
+	Requests []*http.Request
+}
+
+func (tester *PaginatedTester) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	tester.Requests = append(tester.Requests, request)
+	params := request.URL.Query()
+	perPage, _ := strconv.Atoi(params.Get("per_page"))
+	page, _ := strconv.Atoi(params.Get("page"))
+	first := (page - 1) * perPage
+	count := util.Max(0, util.Min(perPage, tester.Total-first))
+	response := searchResponse{TotalCount: tester.Total, Items: make([]githubUser, count)}
+	for i := 0; i < count; i++ {
+		id := first + i
+		response.Items[i] = githubUser{ID: int64(id), Login: fmt.Sprintf("user_%d", id)}
+	}
+	if first+count < tester.Total {
+		params.Set("page", strconv.Itoa(page+1))
+		next := fmt.Sprintf("http://%s%s?%s", request.Host, request.URL.Path, params.Encode())
+		writer.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+	}
+	body, _ := json.Marshal(response)
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(body)
+}
+
+func TestPagination(t *testing.T) {
+	tests := []struct {
+		total, count     int
+		expectedResults  int
+		expectedRequests int
+	}{
+		{total: 5000, count: 1, expectedResults: 1, expectedRequests: 1},
+		{total: 5000, count: 100, expectedResults: 100, expectedRequests: 1},
+		{total: 5000, count: 150, expectedResults: 150, expectedRequests: 2},
+		{total: 5000, count: 999, expectedResults: 999, expectedRequests: 10},
+		{total: 5000, count: 1000, expectedResults: 1000, expectedRequests: 10},
+		{total: 120, count: 500, expectedResults: 120, expectedRequests: 2},
+		{total: 200, count: 500, expectedResults: 200, expectedRequests: 2},
+		{total: 0, count: 50, expectedResults: 0, expectedRequests: 1},
+	}
+	for _, tt := range tests {
+		handler := &PaginatedTester{Total: tt.total}
+		server := httptest.NewServer(handler)
+
+		client, err := NewClient(noAuth, server.URL, timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranking, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: tt.count})
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ranking.Users) != tt.expectedResults {
+			t.Fatalf("total:%d count:%d got %d results", tt.total, tt.count, len(ranking.Users))
+		}
+		if len(handler.Requests) != tt.expectedRequests {
+			t.Fatalf("total:%d count:%d got %d requests", tt.total, tt.count, len(handler.Requests))
+		}
+		for i, user := range ranking.Users {
+			if user.ID != int64(i) {
+				t.Fatalf("unexpected user at position %d: %v", i, user)
+			}
+		}
+	}
+}
+
+func TestCountOutOfRange(t *testing.T) {
+	handler := &PaginatedTester{Total: 5000}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, err := NewClient(noAuth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, count := range []int{0, -1, 1001} {
+		if _, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: count}); err == nil {
+			t.Fatalf("failure expected for count %d", count)
+		}
+	}
+	if len(handler.Requests) != 0 {
+		t.Fatalf("no requests expected, got %d", len(handler.Requests))
+	}
+}
+
+func TestParseLinks(t *testing.T) {
+	header := `<https://api.github.com/search/users?q=x&page=2>; rel="next", ` +
+		`<https://api.github.com/search/users?q=x&page=34>; rel="last"`
+	links := parseLinks(header)
+	if links["next"] != "https://api.github.com/search/users?q=x&page=2" {
+		t.Fatalf("wrong next link: '%s'", links["next"])
+	}
+	if links["last"] != "https://api.github.com/search/users?q=x&page=34" {
+		t.Fatalf("wrong last link: '%s'", links["last"])
+	}
+	if len(parseLinks("")) != 0 {
+		t.Fatal("no links expected")
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		code int
+		body []byte
+		kind util.Kind
+	}{
+		{422, []byte("{}"), util.InvalidQuery},
+		{500, []byte("bye"), util.Unavailable},
+		{502, []byte("bye"), util.Unavailable},
+		{401, []byte("{}"), util.Internal},
+		{200, []byte("not json"), util.Decode},
+	}
+	for _, tt := range tests {
+		handler := &RequestResponseTester{nil, tt.code, tt.body}
+		server := httptest.NewServer(handler)
+		client, err := NewClient(noAuth, server.URL, timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+		server.Close()
+		if kind := util.KindOf(err); kind != tt.kind {
+			t.Errorf("code %d: expected kind '%s', got '%s' (%v)", tt.code, tt.kind, kind, err)
+		}
+	}
+
+	client, err := NewClient(noAuth, "http://127.0.0.1:0", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 0})
+	if !errors.Is(err, util.InvalidQuery) {
+		t.Errorf("expected invalid query, got %v", err)
+	}
+	_, err = client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	if !errors.Is(err, util.Unavailable) {
+		t.Errorf("expected unavailable, got %v", err)
+	}
+}
+
+func TestTimeoutKind(t *testing.T) {
+	handler := &BlockingTester{make(chan struct{}), make(chan struct{})}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, err := NewClient(noAuth, server.URL, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	if !errors.Is(err, util.Timeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestSortOrder(t *testing.T) {
+	handler := &RequestResponseTester{nil, 200, toJSON(t, makeResponse(10, false, 10))}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, err := NewClient(noAuth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sort, order       string
+		expSort, expOrder string
+	}{
+		{"", "", "repositories", "desc"},
+		{model.SortFollowers, "", "followers", "desc"},
+		{model.SortJoined, model.OrderAsc, "joined", "asc"},
+	}
+	for _, tt := range tests {
+		query := model.Query{Location: "Barcelona", Count: 10, Sort: tt.sort, Order: tt.order}
+		if _, err := client.GetTopContributors(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+		params := handler.Request.URL.Query()
+		if params.Get("sort") != tt.expSort || params.Get("order") != tt.expOrder {
+			t.Fatalf("wrong ranking: sort=%s order=%s", params.Get("sort"), params.Get("order"))
+		}
+	}
+}
+
+func TestIncompleteResults(t *testing.T) {
+	incomplete := makeResponse(5000, true, 50)
+	complete := makeResponse(5000, false, 50)
+	tests := []struct {
+		name               string
+		script             []ScriptedResponse
+		retries            int
+		expectedIncomplete bool
+		expectedRequests   int
+	}{
+		{"complete", []ScriptedResponse{{200, nil, toJSON(t, complete)}}, 2, false, 1},
+		{"recovered", []ScriptedResponse{
+			{200, nil, toJSON(t, incomplete)},
+			{200, nil, toJSON(t, complete)}}, 2, false, 2},
+		{"still incomplete", []ScriptedResponse{{200, nil, toJSON(t, incomplete)}}, 2, true, 3},
+		{"no retries", []ScriptedResponse{{200, nil, toJSON(t, incomplete)}}, 0, true, 1},
+	}
+	for _, tt := range tests {
+		client, handler, server := newScriptedClient(t, tt.script...)
+		client.SetIncompleteRetries(tt.retries)
+		ranking, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ranking.Incomplete != tt.expectedIncomplete || ranking.TotalCount != 5000 || len(ranking.Users) != 50 {
+			t.Fatalf("%s: unexpected ranking: incomplete:%v total:%d users:%d", tt.name,
+				ranking.Incomplete, ranking.TotalCount, len(ranking.Users))
+		}
+		if handler.Requests != tt.expectedRequests {
+			t.Fatalf("%s: expected %d requests, got %d", tt.name, tt.expectedRequests, handler.Requests)
+		}
+	}
+}
+
+// Observation is a request or quota update notified to an Observer
+type Observation struct {
+	Resource string
+	Code     int
+	Status   *RateLimitStatus
+}
+
+// ObserverTester records the notifications received
+type ObserverTester struct {
+	Observations []Observation
+}
+
+func (tester *ObserverTester) ObserveRequest(resource string, code int, elapsed time.Duration, err error) {
+	tester.Observations = append(tester.Observations, Observation{resource, code, nil})
+}
+
+func (tester *ObserverTester) ObserveRateLimit(resource string, status RateLimitStatus) {
+	tester.Observations = append(tester.Observations, Observation{resource, 0, &status})
+}
+
+func TestObserver(t *testing.T) {
+	reset := time.Unix(1500000000, 0)
+	client, _, server := newScriptedClient(t,
+		ScriptedResponse{200, quotaHeaders(29, reset), toJSON(t, makeResponse(5000, false, 50))},
+		ScriptedResponse{500, nil, []byte("bye")})
+	defer server.Close()
+	observer := &ObserverTester{}
+	client.AddObserver(observer)
+
+	if _, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetTopContributors(context.Background(), model.Query{Location: "Madrid", Count: 50}); err == nil {
+		t.Fatal("failure expected")
+	}
+	server.Close()
+	if _, err := client.GetTopContributors(context.Background(), model.Query{Location: "Paris", Count: 50}); err == nil {
+		t.Fatal("failure expected")
+	}
+
+	observations := observer.Observations
+	if len(observations) != 5 {
+		t.Fatalf("expected 5 observations, got %+v", observations)
+	}
+	for i, expected := range []Observation{
+		{ResourceSearch, 200, nil},
+		{ResourceSearch, 0, &RateLimitStatus{29, reset}},
+		{ResourceSearch, 500, nil},
+		// a response without quota headers keeps the local accounting
+		{ResourceSearch, 0, &RateLimitStatus{28, reset}},
+		{ResourceSearch, 0, nil},
+	} {
+		actual := observations[i]
+		if actual.Resource != expected.Resource || actual.Code != expected.Code ||
+			(actual.Status == nil) != (expected.Status == nil) ||
+			(actual.Status != nil && (actual.Status.Remaining != expected.Status.Remaining ||
+				!actual.Status.Reset.Equal(expected.Status.Reset))) {
+			t.Fatalf("observation %d: expected %+v, got %+v", i, expected, actual)
+		}
+	}
+}
+
+func TestRequestIDPropagated(t *testing.T) {
+	handler := &RequestResponseTester{nil, 200, toJSON(t, makeResponse(5000, false, 50))}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client, err := NewClient(noAuth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := logging.WithRequestID(context.Background(), "abc123")
+	if _, err := client.GetTopContributors(ctx, model.Query{Location: "Barcelona", Count: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if id := handler.Request.Header.Get(logging.RequestIDHeader); id != "abc123" {
+		t.Fatalf("expected request ID abc123, got '%s'", id)
+	}
+
+	if _, err := client.GetTopContributors(context.Background(), model.Query{Location: "Madrid", Count: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if id := handler.Request.Header.Get(logging.RequestIDHeader); id != "" {
+		t.Fatalf("unexpected request ID '%s'", id)
 	}
-	assertEquals(t, response.Items, result)
 }
@@ -0,0 +1,85 @@
+package githubapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+// BlockingTester never replies, it waits until the request is aborted
+type BlockingTester struct {
+	Started chan struct{}
+	Aborted chan struct{}
+}
+
+func (tester *BlockingTester) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	close(tester.Started)
+	<-request.Context().Done()
+	close(tester.Aborted)
+}
+
+func TestCancelAbortsRequest(t *testing.T) {
+	handler := &BlockingTester{make(chan struct{}), make(chan struct{})}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, err := NewClient(noAuth, server.URL, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.GetTopContributors(ctx, model.Query{Location: "Barcelona", Count: 50})
+		result <- err
+	}()
+
+	<-handler.Started
+	cancel()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatal("failure expected")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("query not aborted")
+	}
+	select {
+	case <-handler.Aborted:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request not aborted")
+	}
+}
+
+func TestCancelAbortsRateLimitWait(t *testing.T) {
+	handler := &RequestResponseTester{nil, 200, toJSON(t, makeResponse(50, false, 50))}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, err := NewClient(noAuth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// quota exhausted, will be reset within MaxWait
+	client.limiter.remaining = 0
+	client.limiter.reset = time.Now().Add(DefaultRateLimitPolicy.MaxWait / 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.GetTopContributors(ctx, model.Query{Location: "Barcelona", Count: 50}); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("wait not aborted, took %s", elapsed)
+	}
+	if handler.Request != nil {
+		t.Fatal("no request expected")
+	}
+}
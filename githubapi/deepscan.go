@@ -0,0 +1,151 @@
+package githubapi
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// GitHub didn't exist before this date, so no account can be older
+var githubLaunch = time.Date(2007, time.October, 20, 0, 0, 0, 0, time.UTC)
+
+// (private) deepScan holds the state of a DeepScan
+type deepScan struct {
+	client  *Client
+	query   model.Query
+	seen    map[int64]bool
+	ranking model.Ranking
+}
+
+// (private) deepScanKey marks the context of the requests of a deep scan
+type deepScanKey struct{}
+
+// DeepScan ranks all the users matching the query, overcoming the limit of
+// 1000 results per search by splitting the query into ranges of account
+// creation dates small enough to be fully listed. As results from different
+// ranges can't be merged using the search order, the profile of every user
+// is fetched to rank them, one request of the core quota per user. This
+// involves a large number of requests, so they are only performed while more
+// requests than the deep scan reserve of their quota remain, otherwise the
+// scan waits for the quota to be reset for as long as needed, ignoring the
+// MaxWait of the RateLimitPolicy. Only cancelling the context aborts the
+// scan. The query Count is ignored.
+func (client *Client) DeepScan(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	if err := query.Qualifiers.Validate(); err != nil {
+		return nil, util.WrapErrorKind(util.InvalidQuery, "invalid qualifiers", err)
+	}
+
+	from, to := query.Created.From, query.Created.To
+	if from.IsZero() || from.Before(githubLaunch) {
+		from = githubLaunch
+	}
+	if to.IsZero() {
+		to = client.limiter.now().UTC().Truncate(24 * time.Hour)
+	}
+	ctx = context.WithValue(ctx, deepScanKey{}, true)
+	scan := &deepScan{client: client, query: query, seen: make(map[int64]bool)}
+	scan.ranking.FetchedAt = client.limiter.now()
+	if !to.Before(from) {
+		if err := scan.slice(ctx, from, to); err != nil {
+			return nil, err
+		}
+	}
+	if err := client.fetchProfiles(ctx, scan.ranking.Users); err != nil {
+		return nil, err
+	}
+	scan.rank()
+	return &scan.ranking, nil
+}
+
+// (private) slice lists the users created between the given dates
+// (inclusive), recursively splitting the range in halves while there are
+// more users than the search API can list
+func (scan *deepScan) slice(ctx context.Context, from, to time.Time) error {
+	query := scan.query
+	query.Created = model.DateRange{From: from, To: to}
+	days := int(to.Sub(from).Hours() / 24)
+	stopAbove := 0
+	if days > 0 {
+		stopAbove = model.MaxContributors
+	}
+	result, err := scan.client.search(ctx, query, model.MaxContributors, stopAbove)
+	if err != nil {
+		return err
+	}
+	if result.TotalCount > model.MaxContributors && days > 0 {
+		middle := from.AddDate(0, 0, days/2)
+		if err := scan.slice(ctx, from, middle); err != nil {
+			return err
+		}
+		return scan.slice(ctx, middle.AddDate(0, 0, 1), to)
+	}
+	// a single day with too many users can't be split further, and GitHub
+	// may fail to provide all the results
+	if result.Incomplete || result.TotalCount > len(result.Users) {
+		scan.ranking.Incomplete = true
+	}
+	scan.ranking.TotalCount += result.TotalCount
+	for _, user := range result.Users {
+		if !scan.seen[user.ID] {
+			scan.seen[user.ID] = true
+			scan.ranking.Users = append(scan.ranking.Users, user)
+		}
+	}
+	return nil
+}
+
+// (private) quotaLimits returns how long a request accounted in the given
+// limiter can wait for its quota, and how many requests of it must be left
+// for other queries. Deep scans wait as long as needed, but leave a reserve
+func (client *Client) quotaLimits(ctx context.Context, limiter *rateLimiter) (time.Duration, int) {
+	switch {
+	case ctx.Value(deepScanKey{}) == nil:
+		return client.policy.MaxWait, 0
+	case limiter == client.coreLimiter:
+		return unlimitedWait, client.deepScanProfileReserve
+	default:
+		return unlimitedWait, client.deepScanReserve
+	}
+}
+
+// (private) rank sorts the users according to the query, using their
+// profiles. Users without a profile no longer exist and are discarded
+func (scan *deepScan) rank() {
+	users := scan.ranking.Users[:0]
+	for _, user := range scan.ranking.Users {
+		if user.Profile != nil {
+			users = append(users, user)
+		}
+	}
+	sortBy, order := scan.query.SortOrder()
+	key := func(user model.User) int64 {
+		switch sortBy {
+		case model.SortFollowers:
+			return int64(user.Profile.Followers)
+		case model.SortJoined:
+			return user.Profile.CreatedAt.Unix()
+		default:
+			return int64(user.Profile.PublicRepos)
+		}
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		a, b := key(users[i]), key(users[j])
+		if a == b {
+			return users[i].ID < users[j].ID
+		}
+		if order == model.OrderAsc {
+			return a < b
+		}
+		return a > b
+	})
+	if !scan.query.Profile {
+		// profiles were only needed for ranking
+		for i := range users {
+			users[i].Profile = nil
+		}
+	}
+	scan.ranking.Users = users
+}
@@ -0,0 +1,243 @@
+package githubapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// PopulationTester simulates the search and profile APIs for `Total`
+// users, one of them created every `Spacing` since `Start`. User `i` has
+// `i` followers. Like GitHub, no more than 1000 results are listed per
+// search
+type PopulationTester struct {
+	Total   int
+	Start   time.Time
+	Spacing time.Duration
+
+	mutex    sync.Mutex
+	Searches []string
+}
+
+var createdQualifier = regexp.MustCompile(`created:(\S+)\.\.(\S+)`)
+
+func (tester *PopulationTester) created(id int) time.Time {
+	return tester.Start.Add(time.Duration(id) * tester.Spacing)
+}
+
+func (tester *PopulationTester) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if strings.HasPrefix(request.URL.Path, "/users/") {
+		id, _ := strconv.Atoi(strings.TrimPrefix(request.URL.Path, "/users/user_"))
+		fmt.Fprintf(writer, `{"login":"user_%d","followers":%d,"public_repos":1,"created_at":"%s"}`,
+			id, id, tester.created(id).Format(time.RFC3339))
+		return
+	}
+	params := request.URL.Query()
+	q := params.Get("q")
+	tester.mutex.Lock()
+	tester.Searches = append(tester.Searches, q)
+	tester.mutex.Unlock()
+
+	var matches []int
+	bounds := createdQualifier.FindStringSubmatch(q)
+	for id := 0; id < tester.Total; id++ {
+		if bounds != nil {
+			day := tester.created(id).Format(model.DateFormat)
+			if day < bounds[1] || day > bounds[2] {
+				continue
+			}
+		}
+		matches = append(matches, id)
+	}
+	perPage, _ := strconv.Atoi(params.Get("per_page"))
+	page, _ := strconv.Atoi(params.Get("page"))
+	first := (page - 1) * perPage
+	listed := util.Min(len(matches), model.MaxContributors)
+	count := util.Max(0, util.Min(perPage, listed-first))
+	response := searchResponse{TotalCount: len(matches), Items: make([]githubUser, count)}
+	for i := 0; i < count; i++ {
+		id := matches[first+i]
+		response.Items[i] = githubUser{ID: int64(id), Login: fmt.Sprintf("user_%d", id)}
+	}
+	if first+count < listed {
+		params.Set("page", strconv.Itoa(page+1))
+		next := fmt.Sprintf("http://%s%s?%s", request.Host, request.URL.Path, params.Encode())
+		writer.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+	}
+	body, _ := json.Marshal(response)
+	writer.Write(body)
+}
+
+func newDeepScanClient(t *testing.T, handler http.Handler) (*Client, func()) {
+	server := httptest.NewServer(handler)
+	client, err := NewClient(noAuth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetProfileConcurrency(16)
+	client.limiter.now = func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+	return client, server.Close
+}
+
+func TestDeepScan(t *testing.T) {
+	handler := &PopulationTester{
+		Total:   2500,
+		Start:   time.Date(2019, 12, 25, 0, 0, 0, 0, time.UTC),
+		Spacing: 4 * time.Minute,
+	}
+	client, closeServer := newDeepScanClient(t, handler)
+	defer closeServer()
+
+	ranking, err := client.DeepScan(context.Background(),
+		model.Query{Location: "Barcelona", Sort: model.SortFollowers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ranking.Incomplete || ranking.TotalCount != handler.Total || len(ranking.Users) != handler.Total {
+		t.Fatalf("wrong ranking: total:%d incomplete:%v users:%d",
+			ranking.TotalCount, ranking.Incomplete, len(ranking.Users))
+	}
+	for i, user := range ranking.Users {
+		if expected := int64(handler.Total - 1 - i); user.ID != expected {
+			t.Fatalf("position %d: expected user %d, got %d", i, expected, user.ID)
+		}
+		if user.Profile != nil {
+			t.Fatal("profile not requested")
+		}
+	}
+	// the range is split until every slice has at most 1000 users
+	for _, q := range handler.Searches {
+		if !strings.Contains(q, "created:") {
+			t.Fatalf("search without date range: %s", q)
+		}
+	}
+	if !strings.Contains(handler.Searches[0], "created:2007-10-20..2020-01-01") {
+		t.Fatalf("unexpected initial range: %s", handler.Searches[0])
+	}
+}
+
+func TestDeepScanAscending(t *testing.T) {
+	handler := &PopulationTester{
+		Total:   1500,
+		Start:   time.Date(2019, 12, 30, 0, 0, 0, 0, time.UTC),
+		Spacing: 2 * time.Minute,
+	}
+	client, closeServer := newDeepScanClient(t, handler)
+	defer closeServer()
+
+	query := model.Query{Location: "Barcelona", Sort: model.SortJoined, Order: model.OrderAsc, Profile: true}
+	query.Created = model.DateRange{From: handler.Start}
+	ranking, err := client.DeepScan(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranking.Users) != handler.Total {
+		t.Fatalf("expected %d users, got %d", handler.Total, len(ranking.Users))
+	}
+	for i, user := range ranking.Users {
+		if user.ID != int64(i) || user.Profile == nil {
+			t.Fatalf("position %d: unexpected user %+v", i, user)
+		}
+	}
+	if !strings.Contains(handler.Searches[0], "created:2019-12-30..2020-01-01") {
+		t.Fatalf("unexpected initial range: %s", handler.Searches[0])
+	}
+}
+
+func TestDeepScanIncomplete(t *testing.T) {
+	// all users created the same day, which can't be split
+	handler := &PopulationTester{
+		Total:   1200,
+		Start:   time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC),
+		Spacing: time.Second,
+	}
+	client, closeServer := newDeepScanClient(t, handler)
+	defer closeServer()
+
+	ranking, err := client.DeepScan(context.Background(), model.Query{Location: "Barcelona"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ranking.Incomplete || ranking.TotalCount != 1200 || len(ranking.Users) != model.MaxContributors {
+		t.Fatalf("wrong ranking: total:%d incomplete:%v users:%d",
+			ranking.TotalCount, ranking.Incomplete, len(ranking.Users))
+	}
+}
+
+func TestDeepScanInvalidQualifiers(t *testing.T) {
+	client, closeServer := newDeepScanClient(t, &PopulationTester{})
+	defer closeServer()
+
+	query := model.Query{Location: "Barcelona"}
+	query.Type = "robot"
+	if _, err := client.DeepScan(context.Background(), query); !errors.Is(err, util.InvalidQuery) {
+		t.Fatalf("expected invalid query, got %v", err)
+	}
+}
+
+func TestDeepScanReserve(t *testing.T) {
+	handler := &PopulationTester{
+		Total:   10,
+		Start:   time.Date(2019, 12, 25, 0, 0, 0, 0, time.UTC),
+		Spacing: time.Hour,
+	}
+	client, closeServer := newDeepScanClient(t, handler)
+	defer closeServer()
+	client.SetDeepScanReserve(5, 100)
+	now := client.limiter.now()
+	client.coreLimiter.now = client.limiter.now
+	client.limiter.remaining, client.limiter.reset = 3, now.Add(time.Minute)
+	client.coreLimiter.remaining, client.coreLimiter.reset = 50, now.Add(time.Hour)
+
+	var mutex sync.Mutex
+	waits := make(map[string][]time.Duration)
+	for _, limiter := range []*rateLimiter{client.limiter, client.coreLimiter} {
+		resource := limiter.resource
+		limiter.sleep = func(ctx context.Context, d time.Duration) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			waits[resource] = append(waits[resource], d)
+			return nil
+		}
+	}
+
+	// other queries can use the reserve
+	if _, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if len(waits) != 0 {
+		t.Fatalf("unexpected waits %v", waits)
+	}
+
+	// deep scans wait for both quotas to be reset, even beyond the maximum
+	// wait of the policy
+	ranking, err := client.DeepScan(context.Background(), model.Query{Location: "Barcelona"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranking.Users) != handler.Total ||
+		len(waits[ResourceSearch]) != len(handler.Searches)-1 || waits[ResourceSearch][0] != time.Minute ||
+		len(waits[ResourceCore]) != handler.Total || waits[ResourceCore][0] != time.Hour {
+		t.Fatalf("unexpected scan: %d users, waits %v", len(ranking.Users), waits)
+	}
+
+	// only cancelling the scan aborts the wait
+	client.limiter.sleep = sleepContext
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.DeepScan(ctx, model.Query{Location: "Barcelona"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the scan to time out, got %v", err)
+	}
+}
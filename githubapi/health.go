@@ -0,0 +1,155 @@
+package githubapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultHealthThreshold is the time GitHub API can be failing or rate
+// limited before it's considered unhealthy
+const DefaultHealthThreshold = 5 * time.Minute
+
+// Health is an Observer that tracks the availability of GitHub API from the
+// requests of a client. GitHub API is unhealthy when all the requests have
+// failed, or a quota has been exhausted, for longer than a threshold. Failures
+// older than the threshold are forgotten, so that the health is restored
+// even if no more requests are made
+type Health struct {
+	threshold time.Duration
+
+	mutex sync.Mutex
+	// first failure since the last successful request, zero if none. Only
+	// relevant while lastErrorAt is within the threshold
+	failingSince time.Time
+	// when the quota of every exhausted resource was exhausted and when it
+	// will be reset
+	limitedSince map[string]time.Time
+	limitedUntil map[string]time.Time
+	lastError    string
+	lastErrorAt  time.Time
+
+	// (private) source of time, replaceable for testing
+	now func() time.Time
+}
+
+// HealthStatus is a snapshot of the availability of GitHub API
+type HealthStatus struct {
+	Healthy bool `json:"healthy"`
+	// why GitHub API is unhealthy
+	Reason string `json:"reason,omitempty"`
+	// first failure since the last successful request
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+	// since when a quota is exhausted, and when it will be reset
+	RateLimitedSince *time.Time `json:"rate_limited_since,omitempty"`
+	RateLimitedUntil *time.Time `json:"rate_limited_until,omitempty"`
+	// last failed request
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// NewHealth returns a Health that considers GitHub API unhealthy after
+// failing or being rate limited for `threshold`, or DefaultHealthThreshold
+// if zero
+func NewHealth(threshold time.Duration) *Health {
+	if threshold <= 0 {
+		threshold = DefaultHealthThreshold
+	}
+	return &Health{
+		threshold:    threshold,
+		limitedSince: make(map[string]time.Time),
+		limitedUntil: make(map[string]time.Time),
+		now:          time.Now,
+	}
+}
+
+// ObserveRequest records the outcome of a request. Server errors and
+// rejections due to rate limits are failures, as well as requests without a
+// response, unless they were cancelled by the caller
+func (health *Health) ObserveRequest(resource string, code int, elapsed time.Duration, err error) {
+	var failure string
+	switch {
+	case err != nil:
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		failure = err.Error()
+	case code >= 500 || code == http.StatusForbidden || code == http.StatusTooManyRequests:
+		failure = fmt.Sprintf("%s request failed with code %d", resource, code)
+	}
+
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	if len(failure) == 0 {
+		health.failingSince = time.Time{}
+		return
+	}
+	now := health.now()
+	if !health.failing(now) {
+		health.failingSince = now
+	}
+	health.lastError, health.lastErrorAt = failure, now
+}
+
+// ObserveRateLimit records whether the quota of a resource is exhausted
+func (health *Health) ObserveRateLimit(resource string, status RateLimitStatus) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	now := health.now()
+	if status.Remaining != 0 || !status.Reset.After(now) {
+		delete(health.limitedSince, resource)
+		delete(health.limitedUntil, resource)
+		return
+	}
+	if _, found := health.limitedSince[resource]; !found {
+		health.limitedSince[resource] = now
+	}
+	health.limitedUntil[resource] = status.Reset
+}
+
+// Status returns the current availability of GitHub API
+func (health *Health) Status() HealthStatus {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	now := health.now()
+	status := HealthStatus{Healthy: true, LastError: health.lastError}
+	if !health.lastErrorAt.IsZero() {
+		status.LastErrorAt = timePointer(health.lastErrorAt)
+	}
+	if health.failing(now) {
+		status.FailingSince = timePointer(health.failingSince)
+		if now.Sub(health.failingSince) >= health.threshold {
+			status.Healthy = false
+			status.Reason = "GitHub API requests failing since " + health.failingSince.Format(time.RFC3339)
+		}
+	}
+	// the resource that has been exhausted for longer, as long as its quota
+	// hasn't been reset
+	for resource, since := range health.limitedSince {
+		until := health.limitedUntil[resource]
+		if !until.After(now) || (status.RateLimitedSince != nil && !since.Before(*status.RateLimitedSince)) {
+			continue
+		}
+		status.RateLimitedSince, status.RateLimitedUntil = timePointer(since), timePointer(until)
+	}
+	if status.Healthy && status.RateLimitedSince != nil && now.Sub(*status.RateLimitedSince) >= health.threshold {
+		status.Healthy = false
+		status.Reason = "GitHub API rate limited until " + status.RateLimitedUntil.Format(time.RFC3339)
+	}
+	return status
+}
+
+// (private) failing returns if requests have been failing, without a
+// successful one, until less than the threshold ago. Must be called with the
+// mutex held
+func (health *Health) failing(now time.Time) bool {
+	return !health.failingSince.IsZero() && now.Sub(health.lastErrorAt) < health.threshold
+}
+
+// (private) timePointer returns a pointer to a copy of the given time
+func timePointer(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,126 @@
+package githubapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+func newTestHealth(threshold time.Duration) (*Health, *time.Time) {
+	health := NewHealth(threshold)
+	now := time.Unix(1500000000, 0)
+	health.now = func() time.Time { return now }
+	return health, &now
+}
+
+func TestHealthFailures(t *testing.T) {
+	health, now := newTestHealth(time.Minute)
+
+	health.ObserveRequest(ResourceSearch, 200, time.Millisecond, nil)
+	if status := health.Status(); !status.Healthy || status.FailingSince != nil || len(status.LastError) > 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	health.ObserveRequest(ResourceSearch, 502, time.Millisecond, nil)
+	*now = now.Add(59 * time.Second)
+	health.ObserveRequest(ResourceSearch, 0, time.Millisecond, errors.New("connection refused"))
+	status := health.Status()
+	if !status.Healthy || status.LastError != "connection refused" || !status.FailingSince.Equal(now.Add(-59*time.Second)) {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	// unhealthy once the threshold is reached
+	*now = now.Add(time.Second)
+	if status := health.Status(); status.Healthy || len(status.Reason) == 0 {
+		t.Fatalf("expected unhealthy, got %+v", status)
+	}
+
+	// requests cancelled by the caller don't count
+	health.ObserveRequest(ResourceSearch, 0, time.Millisecond, context.Canceled)
+	if status := health.Status(); status.LastError != "connection refused" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// a successful request restores the health, keeping the last error
+	health.ObserveRequest(ResourceCore, 404, time.Millisecond, nil)
+	if status := health.Status(); !status.Healthy || status.FailingSince != nil ||
+		status.LastError != "connection refused" || !status.LastErrorAt.Equal(now.Add(-time.Second)) {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestHealthRecoversWithoutRequests(t *testing.T) {
+	health, now := newTestHealth(time.Minute)
+
+	// a single failure followed by silence is never unhealthy
+	health.ObserveRequest(ResourceSearch, 502, time.Millisecond, nil)
+	*now = now.Add(2 * time.Minute)
+	if status := health.Status(); !status.Healthy || status.FailingSince != nil || len(status.LastError) == 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// a later failure starts a new period of failures
+	health.ObserveRequest(ResourceSearch, 502, time.Millisecond, nil)
+	status := health.Status()
+	if !status.Healthy || !status.FailingSince.Equal(*now) {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	*now = now.Add(59 * time.Second)
+	health.ObserveRequest(ResourceSearch, 502, time.Millisecond, nil)
+	*now = now.Add(time.Second)
+	if status := health.Status(); status.Healthy {
+		t.Fatalf("expected unhealthy, got %+v", status)
+	}
+
+	// and is forgotten once the last failure is older than the threshold
+	*now = now.Add(time.Minute)
+	if status := health.Status(); !status.Healthy {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestHealthRateLimits(t *testing.T) {
+	health, now := newTestHealth(time.Minute)
+	reset := now.Add(time.Hour)
+
+	health.ObserveRateLimit(ResourceCore, RateLimitStatus{10, reset})
+	health.ObserveRateLimit(ResourceSearch, RateLimitStatus{0, reset})
+	*now = now.Add(30 * time.Second)
+	health.ObserveRateLimit(ResourceCore, RateLimitStatus{0, reset})
+	health.ObserveRateLimit(ResourceSearch, RateLimitStatus{0, reset})
+	status := health.Status()
+	if !status.Healthy || !status.RateLimitedSince.Equal(now.Add(-30*time.Second)) || !status.RateLimitedUntil.Equal(reset) {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	*now = now.Add(30 * time.Second)
+	if status := health.Status(); status.Healthy {
+		t.Fatalf("expected unhealthy, got %+v", status)
+	}
+
+	// the search quota is restored, but core has been exhausted for less
+	// than the threshold
+	health.ObserveRateLimit(ResourceSearch, RateLimitStatus{29, reset})
+	if status := health.Status(); !status.Healthy || !status.RateLimitedSince.Equal(now.Add(-30*time.Second)) {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// quotas are restored once reset
+	*now = reset
+	if status := health.Status(); !status.Healthy || status.RateLimitedSince != nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestHealthObservesClient(t *testing.T) {
+	client, _, server := newScriptedClient(t, ScriptedResponse{500, nil, []byte("bye")})
+	defer server.Close()
+	health := NewHealth(time.Minute)
+	client.AddObserver(health)
+
+	client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	if status := health.Status(); status.FailingSince == nil || status.LastError != "search request failed with code 500" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,49 @@
+package githubapi
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/adriansr/github-api-service/metrics"
+)
+
+// (private) metricsObserver is an Observer that records the requests to
+// GitHub API in a metrics registry
+type metricsObserver struct {
+	requests  *metrics.Counter
+	duration  *metrics.Histogram
+	remaining *metrics.Gauge
+}
+
+// RegisterMetrics registers the metrics of the requests to GitHub API and
+// the remaining quota in the given registry. Not safe to call while queries
+// are in progress
+func (client *Client) RegisterMetrics(registry *metrics.Registry) {
+	client.AddObserver(&metricsObserver{
+		requests: registry.NewCounter("ghas_github_requests_total",
+			"Requests sent to GitHub API, by rate limit resource and status code (error when no response).",
+			"resource", "code"),
+		duration: registry.NewHistogram("ghas_github_request_duration_seconds",
+			"Time to receive a response from GitHub API, by rate limit resource.",
+			metrics.DefaultBuckets, "resource"),
+		remaining: registry.NewGauge("ghas_github_rate_limit_remaining",
+			"Requests remaining in the GitHub API quota, by rate limit resource.",
+			"resource"),
+	})
+}
+
+func (observer *metricsObserver) ObserveRequest(resource string, code int, elapsed time.Duration, err error) {
+	label := "error"
+	if code != 0 {
+		label = strconv.Itoa(code)
+	}
+	observer.requests.Inc(resource, label)
+	observer.duration.Observe(elapsed.Seconds(), resource)
+}
+
+func (observer *metricsObserver) ObserveRateLimit(resource string, status RateLimitStatus) {
+	// the quota is unknown until GitHub reports it
+	if status.Remaining >= 0 {
+		observer.remaining.Set(float64(status.Remaining), resource)
+	}
+}
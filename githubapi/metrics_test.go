@@ -0,0 +1,44 @@
+package githubapi
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/metrics"
+	"github.com/adriansr/github-api-service/model"
+)
+
+func TestMetrics(t *testing.T) {
+	reset := time.Now().Add(time.Hour)
+	client, _, server := newScriptedClient(t,
+		ScriptedResponse{200, quotaHeaders(29, reset), toJSON(t, makeResponse(5000, false, 50))},
+		ScriptedResponse{500, quotaHeaders(28, reset), []byte("bye")})
+	registry := metrics.NewRegistry()
+	client.RegisterMetrics(registry)
+
+	for _, location := range []string{"Barcelona", "Madrid"} {
+		client.GetTopContributors(context.Background(), model.Query{Location: location, Count: 50})
+	}
+	server.Close()
+	client.GetTopContributors(context.Background(), model.Query{Location: "Paris", Count: 50})
+
+	var buffer bytes.Buffer
+	if err := registry.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	body := buffer.String()
+	for _, series := range []string{
+		`ghas_github_requests_total{resource="search",code="200"} 1`,
+		`ghas_github_requests_total{resource="search",code="500"} 1`,
+		`ghas_github_requests_total{resource="search",code="error"} 1`,
+		`ghas_github_request_duration_seconds_count{resource="search"} 3`,
+		`ghas_github_rate_limit_remaining{resource="search"} 28`,
+	} {
+		if !strings.Contains(body, series+"\n") {
+			t.Fatalf("missing series %s in:\n%s", series, body)
+		}
+	}
+}
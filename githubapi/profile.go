@@ -0,0 +1,88 @@
+package githubapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+// (private) representation of a github user as returned by the users API,
+// featuring only the required fields
+type githubProfile struct {
+	Name        string    `json:"name"`
+	Company     string    `json:"company"`
+	Blog        string    `json:"blog"`
+	Followers   int       `json:"followers"`
+	PublicRepos int       `json:"public_repos"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// (private) fetchProfiles sets the Profile of every user, fetching up to
+// `profileConcurrency` profiles in parallel. Users that no longer exist are
+// left without a profile. The first failure aborts all pending requests
+func (client *Client) fetchProfiles(ctx context.Context, users []model.User) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var failure error
+	semaphore := make(chan struct{}, client.profileConcurrency)
+	for idx := range users {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(user *model.User) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			profile, err := client.fetchProfile(ctx, user.Username)
+			if err != nil {
+				once.Do(func() {
+					failure = err
+					cancel()
+				})
+				return
+			}
+			user.Profile = profile
+		}(&users[idx])
+	}
+	wg.Wait()
+	if failure == nil {
+		// cancelled by the caller
+		failure = ctx.Err()
+	}
+	return failure
+}
+
+// (private) fetchProfile requests the profile of a single user. Returns nil
+// without error if the user doesn't exist
+func (client *Client) fetchProfile(ctx context.Context, login string) (*model.Profile, error) {
+	var profile githubProfile
+	url := fmt.Sprintf("%s/users/%s", client.apiUrl, url.PathEscape(login))
+	if _, err := client.getJSON(ctx, client.coreLimiter, url, &profile); err != nil {
+		var status statusError
+		if errors.As(err, &status) && status.code == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &model.Profile{
+		Name:        profile.Name,
+		Company:     profile.Company,
+		Blog:        profile.Blog,
+		Followers:   profile.Followers,
+		PublicRepos: profile.PublicRepos,
+		CreatedAt:   profile.CreatedAt,
+	}, nil
+}
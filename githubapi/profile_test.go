@@ -0,0 +1,162 @@
+package githubapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+// ProfileTester serves search results for `Total` users and their
+// profiles, recording the maximum number of concurrent profile requests
+type ProfileTester struct {
+	Total    int
+	Missing  map[string]bool
+	FailCode int
+	Delay    time.Duration
+
+	// protected by mutex, as profiles are requested concurrently
+	mutex    sync.Mutex
+	active   int
+	maxSeen  int
+	profiles int
+}
+
+// (private) counts returns the number of profile requests received and
+// the maximum seen in parallel
+func (tester *ProfileTester) counts() (int, int) {
+	tester.mutex.Lock()
+	defer tester.mutex.Unlock()
+	return tester.profiles, tester.maxSeen
+}
+
+func (tester *ProfileTester) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path == "/search/users" {
+		response := makeResponse(tester.Total, false, tester.Total)
+		for i := range response.Items {
+			response.Items[i].AvatarURL = "https://avatars/" + response.Items[i].Login
+			response.Items[i].Type = "User"
+			response.Items[i].Score = 1
+		}
+		body, _ := json.Marshal(response)
+		writer.Write(body)
+		return
+	}
+	login := strings.TrimPrefix(request.URL.Path, "/users/")
+
+	tester.mutex.Lock()
+	tester.profiles++
+	tester.active++
+	if tester.active > tester.maxSeen {
+		tester.maxSeen = tester.active
+	}
+	tester.mutex.Unlock()
+	time.Sleep(tester.Delay)
+	tester.mutex.Lock()
+	tester.active--
+	tester.mutex.Unlock()
+
+	if tester.Missing[login] {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if tester.FailCode != 0 {
+		writer.WriteHeader(tester.FailCode)
+		return
+	}
+	fmt.Fprintf(writer, `{"login":"%s","name":"Name of %s","company":"ACME","blog":null,
+		"followers":10,"public_repos":20,"created_at":"2010-01-02T03:04:05Z"}`, login, login)
+}
+
+func TestBasicFields(t *testing.T) {
+	handler := &ProfileTester{Total: 10}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, err := NewClient(noAuth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranking, err := client.GetTopContributors(context.Background(),
+		model.Query{Location: "Barcelona", Count: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := ranking.Users[3]
+	if user.AvatarURL != "https://avatars/user_3" || user.Type != "User" || user.Score != 1 {
+		t.Fatalf("missing search fields: %+v", user)
+	}
+	if profiles, _ := handler.counts(); user.Profile != nil || profiles != 0 {
+		t.Fatal("profiles not requested")
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	handler := &ProfileTester{Total: 20, Delay: 5 * time.Millisecond,
+		Missing: map[string]bool{"user_7": true}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, err := NewClient(noAuth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetProfileConcurrency(3)
+	ranking, err := client.GetTopContributors(context.Background(),
+		model.Query{Location: "Barcelona", Count: 20, Profile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles, maxSeen := handler.counts()
+	if profiles != 20 {
+		t.Fatalf("expected 20 profile requests, got %d", profiles)
+	}
+	if maxSeen > 3 {
+		t.Fatalf("concurrency bound exceeded: %d", maxSeen)
+	}
+	for i, user := range ranking.Users {
+		if i == 7 {
+			if user.Profile != nil {
+				t.Fatalf("missing user has a profile: %+v", user.Profile)
+			}
+			continue
+		}
+		expected := model.Profile{
+			Name:        "Name of " + user.Username,
+			Company:     "ACME",
+			Followers:   10,
+			PublicRepos: 20,
+			CreatedAt:   time.Date(2010, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+		if user.Profile == nil || *user.Profile != expected {
+			t.Fatalf("wrong profile for %s: %+v", user.Username, user.Profile)
+		}
+	}
+}
+
+func TestProfileFailure(t *testing.T) {
+	handler := &ProfileTester{Total: 50, FailCode: 500}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, err := NewClient(noAuth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetProfileConcurrency(2)
+	_, err = client.GetTopContributors(context.Background(),
+		model.Query{Location: "Barcelona", Count: 50, Profile: true})
+	if err == nil {
+		t.Fatal("failure expected")
+	}
+	if profiles, _ := handler.counts(); profiles >= 50 {
+		t.Fatalf("pending requests not aborted: %d profile requests", profiles)
+	}
+}
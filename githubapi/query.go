@@ -0,0 +1,76 @@
+package githubapi
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+// (private) searchQuery renders a query into the syntax used by the `q`
+// parameter of GitHub search API, for example:
+//
+//	location:"Berlin" language:"go" followers:>=100 type:user
+//
+// Free-text values are always quoted so that they can't introduce
+// additional qualifiers
+func searchQuery(query model.Query) string {
+	terms := []string{"location:" + quote(query.Location)}
+	if len(query.Language) > 0 {
+		terms = append(terms, "language:"+quote(query.Language))
+	}
+	if r := intRange(query.Followers); len(r) > 0 {
+		terms = append(terms, "followers:"+r)
+	}
+	if r := intRange(query.Repos); len(r) > 0 {
+		terms = append(terms, "repos:"+r)
+	}
+	if r := dateRange(query.Created); len(r) > 0 {
+		terms = append(terms, "created:"+r)
+	}
+	if len(query.Type) > 0 {
+		terms = append(terms, "type:"+query.Type)
+	}
+	return strings.Join(terms, " ")
+}
+
+// (private) quote encloses a value in double quotes. As the search syntax
+// doesn't support escaping, quotes and control characters are removed
+func quote(value string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '"' || r == '\\' || r < ' ' {
+			return ' '
+		}
+		return r
+	}, value)
+	return `"` + strings.Join(strings.Fields(cleaned), " ") + `"`
+}
+
+// (private) intRange renders a range qualifier value, or an empty string
+// for an unbounded range
+func intRange(r model.IntRange) string {
+	switch {
+	case r.Min != nil && r.Max != nil:
+		return strconv.Itoa(*r.Min) + ".." + strconv.Itoa(*r.Max)
+	case r.Min != nil:
+		return ">=" + strconv.Itoa(*r.Min)
+	case r.Max != nil:
+		return "<=" + strconv.Itoa(*r.Max)
+	}
+	return ""
+}
+
+// (private) dateRange renders a date range qualifier value, or an empty
+// string for an unbounded range
+func dateRange(r model.DateRange) string {
+	from, to := r.From.Format(model.DateFormat), r.To.Format(model.DateFormat)
+	switch {
+	case !r.From.IsZero() && !r.To.IsZero():
+		return from + ".." + to
+	case !r.From.IsZero():
+		return ">=" + from
+	case !r.To.IsZero():
+		return "<=" + to
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package githubapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+func intPtr(value int) *int {
+	return &value
+}
+
+func date(value string) time.Time {
+	parsed, err := time.Parse(model.DateFormat, value)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func TestSearchQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    model.Query
+		expected string
+	}{
+		{
+			name:     "location only",
+			query:    model.Query{Location: "Barcelona"},
+			expected: `location:"Barcelona"`,
+		},
+		{
+			name:     "location with spaces",
+			query:    model.Query{Location: "  Rio de   Janeiro "},
+			expected: `location:"Rio de Janeiro"`,
+		},
+		{
+			name:     "qualifier injection",
+			query:    model.Query{Location: `Berlin" type:org followers:>1000 "x`},
+			expected: `location:"Berlin type:org followers:>1000 x"`,
+		},
+		{
+			name:     "control characters",
+			query:    model.Query{Location: "Ber\nlin\\"},
+			expected: `location:"Ber lin"`,
+		},
+		{
+			name: "language",
+			query: model.Query{Location: "Berlin",
+				Qualifiers: model.Qualifiers{Language: "c++"}},
+			expected: `location:"Berlin" language:"c++"`,
+		},
+		{
+			name: "ranges",
+			query: model.Query{Location: "Berlin", Qualifiers: model.Qualifiers{
+				Followers: model.IntRange{Min: intPtr(100)},
+				Repos:     model.IntRange{Min: intPtr(0), Max: intPtr(50)},
+			}},
+			expected: `location:"Berlin" followers:>=100 repos:0..50`,
+		},
+		{
+			name: "upper bound",
+			query: model.Query{Location: "Berlin", Qualifiers: model.Qualifiers{
+				Followers: model.IntRange{Max: intPtr(10)},
+			}},
+			expected: `location:"Berlin" followers:<=10`,
+		},
+		{
+			name: "dates",
+			query: model.Query{Location: "Berlin", Qualifiers: model.Qualifiers{
+				Created: model.DateRange{From: date("2010-01-01"), To: date("2012-12-31")},
+			}},
+			expected: `location:"Berlin" created:2010-01-01..2012-12-31`,
+		},
+		{
+			name: "open dates",
+			query: model.Query{Location: "Berlin", Qualifiers: model.Qualifiers{
+				Created: model.DateRange{To: date("2012-12-31")},
+			}},
+			expected: `location:"Berlin" created:<=2012-12-31`,
+		},
+		{
+			name: "all combined",
+			query: model.Query{Location: "Berlin", Qualifiers: model.Qualifiers{
+				Language:  "Go",
+				Followers: model.IntRange{Min: intPtr(101)},
+				Repos:     model.IntRange{Min: intPtr(5)},
+				Created:   model.DateRange{From: date("2015-06-01")},
+				Type:      model.TypeUser,
+			}},
+			expected: `location:"Berlin" language:"Go" followers:>=101 repos:>=5 created:>=2015-06-01 type:user`,
+		},
+	}
+	for _, tt := range tests {
+		if got := searchQuery(tt.query); got != tt.expected {
+			t.Errorf("%s: got '%s' expected '%s'", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestQualifiersValidation(t *testing.T) {
+	invalid := []model.Qualifiers{
+		{Language: `go" type:org`},
+		{Followers: model.IntRange{Min: intPtr(-1)}},
+		{Repos: model.IntRange{Min: intPtr(10), Max: intPtr(5)}},
+		{Created: model.DateRange{From: date("2015-01-01"), To: date("2014-01-01")}},
+		{Type: "bot"},
+	}
+	client, err := NewClient(noAuth, "http://127.0.0.1:0", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, qualifiers := range invalid {
+		if qualifiers.Validate() == nil {
+			t.Errorf("%+v: validation failure expected", qualifiers)
+		}
+		query := model.Query{Location: "Berlin", Count: 10, Qualifiers: qualifiers}
+		if _, err := client.GetTopContributors(context.Background(), query); err == nil {
+			t.Errorf("%+v: query failure expected", qualifiers)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package githubapi
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adriansr/github-api-service/util"
+)
+
+// RateLimitPolicy controls how the client reacts to GitHub API rate limits
+type RateLimitPolicy struct {
+	// MaxWait is the maximum time a query will wait for the rate limit to
+	// be lifted before being rejected
+	MaxWait time.Duration
+	// MaxRetries is the number of times a request is retried after hitting
+	// a secondary rate limit
+	MaxRetries int
+	// Backoff is the delay before the first retry when GitHub doesn't
+	// provide a Retry-After header. It is doubled for every retry
+	Backoff time.Duration
+}
+
+// DefaultRateLimitPolicy is the policy used by newly created clients
+var DefaultRateLimitPolicy = RateLimitPolicy{
+	MaxWait:    5 * time.Second,
+	MaxRetries: 2,
+	Backoff:    time.Second,
+}
+
+// (private) unlimitedWait lets a request wait for the quota as long as needed
+const unlimitedWait = time.Duration(math.MaxInt64)
+
+// RateLimitStatus is a snapshot of the remaining quota as advertised by
+// the GitHub API responses
+type RateLimitStatus struct {
+	// Remaining number of requests, or -1 if unknown
+	Remaining int
+	// Reset is the time when the quota will be restored
+	Reset time.Time
+}
+
+// (private) rateLimiter tracks the search API quota from the X-RateLimit-*
+// headers in the responses
+type rateLimiter struct {
+	// name of the quota, as GitHub calls it
+	resource  string
+	mutex     sync.Mutex
+	remaining int
+	reset     time.Time
+
+	// sources of time, replaceable for testing
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+func newRateLimiter(resource string) *rateLimiter {
+	return &rateLimiter{resource: resource, remaining: -1, now: time.Now, sleep: sleepContext}
+}
+
+// (private) status returns the current quota
+func (limiter *rateLimiter) status() RateLimitStatus {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return RateLimitStatus{limiter.remaining, limiter.reset}
+}
+
+// (private) acquire consumes a request from the quota. If no more than
+// `reserve` requests remain it waits for the quota to be reset, as long as that
+// happens within `maxWait`, otherwise a RateLimited error is returned. Waiting
+// is aborted when the context is cancelled
+func (limiter *rateLimiter) acquire(ctx context.Context, maxWait time.Duration, reserve int) error {
+	return limiter.wait(ctx, maxWait, reserve, true)
+}
+
+// (private) wait is like acquire but, when `consume` is false, only checks
+// for available quota without consuming it
+func (limiter *rateLimiter) wait(ctx context.Context, maxWait time.Duration, reserve int, consume bool) error {
+	limiter.mutex.Lock()
+	if limiter.remaining < 0 || limiter.remaining > reserve {
+		if limiter.remaining > 0 && consume {
+			limiter.remaining--
+		}
+		limiter.mutex.Unlock()
+		return nil
+	}
+	wait := limiter.reset.Sub(limiter.now())
+	if wait <= 0 {
+		// quota is assumed to be restored, until told otherwise
+		limiter.remaining = -1
+		limiter.mutex.Unlock()
+		return nil
+	}
+	limiter.mutex.Unlock()
+	if wait > maxWait {
+		return util.NewRateLimitError("GitHub API rate limit exceeded", wait)
+	}
+	return limiter.sleep(ctx, wait)
+}
+
+// (private) update refreshes the quota from the headers of a response
+func (limiter *rateLimiter) update(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.remaining = remaining
+	limiter.reset = time.Unix(reset, 0)
+}
+
+// (private) sleepContext waits for the given duration, returning early
+// with the context's error if it is cancelled
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// (private) isRateLimited returns if a response was rejected due to a
+// rate limit, either the primary quota or a secondary limit
+func isRateLimited(response *http.Response) bool {
+	if response.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return response.StatusCode == http.StatusForbidden &&
+		(response.Header.Get("X-RateLimit-Remaining") == "0" ||
+			len(response.Header.Get("Retry-After")) > 0)
+}
+
+// (private) retryAfter parses the Retry-After header, which can contain
+// either a number of seconds or an HTTP date. Returns false if missing
+// or invalid
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now), true
+	}
+	return 0, false
+}
@@ -0,0 +1,244 @@
+package githubapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/util"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+// ScriptedTester replies to each request with the next response in the
+// script, repeating the last one when exhausted
+type ScriptedTester struct {
+	Script   []ScriptedResponse
+	Requests int
+}
+
+type ScriptedResponse struct {
+	Code    int
+	Headers map[string]string
+	Body    []byte
+}
+
+func (tester *ScriptedTester) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	response := tester.Script[util.Min(tester.Requests, len(tester.Script)-1)]
+	tester.Requests++
+	for key, value := range response.Headers {
+		writer.Header().Set(key, value)
+	}
+	writer.WriteHeader(response.Code)
+	writer.Write(response.Body)
+}
+
+// fakeTime replaces the limiter clock with a fixed time, and records sleeps
+// instead of blocking
+func fakeTime(client *Client, now time.Time) *[]time.Duration {
+	var slept []time.Duration
+	client.limiter.now = func() time.Time {
+		return now
+	}
+	client.limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		now = now.Add(d)
+		return nil
+	}
+	return &slept
+}
+
+func quotaHeaders(remaining int, reset time.Time) map[string]string {
+	return map[string]string{
+		"X-RateLimit-Remaining": strconv.Itoa(remaining),
+		"X-RateLimit-Reset":     strconv.FormatInt(reset.Unix(), 10),
+	}
+}
+
+func newScriptedClient(t *testing.T, script ...ScriptedResponse) (*Client, *ScriptedTester, *httptest.Server) {
+	handler := &ScriptedTester{Script: script}
+	server := httptest.NewServer(handler)
+	client, err := NewClient(noAuth, server.URL, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, handler, server
+}
+
+func TestPrimaryRateLimitRejected(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	client, handler, server := newScriptedClient(t,
+		ScriptedResponse{403, quotaHeaders(0, now.Add(time.Hour)), []byte("{}")})
+	defer server.Close()
+	slept := fakeTime(client, now)
+
+	_, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	if !errors.Is(err, util.RateLimited) {
+		t.Fatalf("expected a rate limit error, got %v", err)
+	}
+	if retry := util.RetryAfter(err); retry != time.Hour {
+		t.Fatalf("wrong retry after: %s", retry)
+	}
+	if handler.Requests != 1 || len(*slept) != 0 {
+		t.Fatalf("unexpected retries: %d requests, slept %v", handler.Requests, *slept)
+	}
+
+	// further queries are rejected without reaching the API
+	if _, err = client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err == nil {
+		t.Fatal("failure expected")
+	}
+	if handler.Requests != 1 {
+		t.Fatalf("no new requests expected, got %d", handler.Requests)
+	}
+	if status := client.RateLimit(); status.Remaining != 0 || !status.Reset.Equal(now.Add(time.Hour)) {
+		t.Fatalf("wrong rate limit status: %v", status)
+	}
+}
+
+func TestQuotaTracking(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	body := toJSON(t, makeResponse(5000, false, 50))
+	client, handler, server := newScriptedClient(t,
+		ScriptedResponse{200, quotaHeaders(0, now.Add(3*time.Second)), body},
+		ScriptedResponse{200, quotaHeaders(9, now.Add(time.Minute)), body})
+	defer server.Close()
+	slept := fakeTime(client, now)
+
+	if _, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err != nil {
+		t.Fatal(err)
+	}
+	// quota is exhausted but will be reset within MaxWait
+	if _, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if handler.Requests != 2 {
+		t.Fatalf("two requests expected, got %d", handler.Requests)
+	}
+	if len(*slept) != 1 || (*slept)[0] != 3*time.Second {
+		t.Fatalf("expected to wait for quota, slept %v", *slept)
+	}
+	if status := client.RateLimit(); status.Remaining != 9 {
+		t.Fatalf("wrong rate limit status: %v", status)
+	}
+}
+
+func TestSecondaryRateLimitRetried(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	body := toJSON(t, makeResponse(5000, false, 50))
+	client, handler, server := newScriptedClient(t,
+		ScriptedResponse{403, map[string]string{"Retry-After": "2"}, []byte("{}")},
+		ScriptedResponse{429, nil, []byte("{}")},
+		ScriptedResponse{200, nil, body})
+	defer server.Close()
+	slept := fakeTime(client, now)
+
+	ranking, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranking.Users) != 50 {
+		t.Fatalf("result: %v", ranking.Users)
+	}
+	if handler.Requests != 3 {
+		t.Fatalf("three requests expected, got %d", handler.Requests)
+	}
+	// Retry-After honored, then backoff for second retry
+	expected := []time.Duration{2 * time.Second, 2 * time.Second}
+	if len(*slept) != 2 || (*slept)[0] != expected[0] || (*slept)[1] != expected[1] {
+		t.Fatalf("expected sleeps %v, got %v", expected, *slept)
+	}
+}
+
+func TestSecondaryRateLimitExhausted(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	client, handler, server := newScriptedClient(t,
+		ScriptedResponse{403, map[string]string{"Retry-After": "1"}, []byte("{}")})
+	defer server.Close()
+	fakeTime(client, now)
+
+	_, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	if !errors.Is(err, util.RateLimited) {
+		t.Fatalf("expected a rate limit error, got %v", err)
+	}
+	if handler.Requests != DefaultRateLimitPolicy.MaxRetries+1 {
+		t.Fatalf("%d requests expected, got %d",
+			DefaultRateLimitPolicy.MaxRetries+1, handler.Requests)
+	}
+}
+
+func TestForbiddenIsNotRateLimit(t *testing.T) {
+	client, handler, server := newScriptedClient(t,
+		ScriptedResponse{403, nil, []byte("{}")})
+	defer server.Close()
+
+	_, err := client.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	if err == nil {
+		t.Fatal("failure expected")
+	}
+	if errors.Is(err, util.RateLimited) {
+		t.Fatalf("unexpected rate limit error: %v", err)
+	}
+	if handler.Requests != 1 {
+		t.Fatalf("one request expected, got %d", handler.Requests)
+	}
+}
+
+func TestRetryAfterParsing(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	header := http.Header{}
+	if _, found := retryAfter(header, now); found {
+		t.Fatal("missing header parsed")
+	}
+	header.Set("Retry-After", "120")
+	if delay, _ := retryAfter(header, now); delay != 2*time.Minute {
+		t.Fatalf("wrong delay: %s", delay)
+	}
+	header.Set("Retry-After", now.Add(time.Minute).UTC().Format(http.TimeFormat))
+	if delay, _ := retryAfter(header, now); delay != time.Minute {
+		t.Fatalf("wrong delay: %s", delay)
+	}
+	header.Set("Retry-After", "soon")
+	if _, found := retryAfter(header, now); found {
+		t.Fatal("invalid header parsed")
+	}
+}
+
+func TestAwaitQuota(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	client, err := NewClient(noAuth, "", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slept := fakeTime(client, now)
+
+	if err := client.AwaitQuota(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	client.limiter.update(http.Header{
+		"X-Ratelimit-Remaining": {"1"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(now.Add(time.Hour).Unix(), 10)},
+	})
+	// checking doesn't consume quota
+	for i := 0; i < 3; i++ {
+		if err := client.AwaitQuota(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := client.RateLimit(); status.Remaining != 1 {
+		t.Fatalf("quota consumed: %v", status)
+	}
+	client.limiter.update(http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(now.Add(time.Hour).Unix(), 10)},
+	})
+	if !errors.Is(client.AwaitQuota(context.Background()), util.RateLimited) {
+		t.Fatal("expected a rate limit error")
+	}
+	if len(*slept) != 0 {
+		t.Fatalf("unexpected wait: %v", *slept)
+	}
+}
@@ -0,0 +1,107 @@
+// Package logging creates the structured logger used across the service and
+// propagates request IDs, so that all the log lines caused by a request
+// can be correlated
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// RequestIDHeader is the header that carries the request ID, both in the
+// requests to the service and in the requests to GitHub API
+const RequestIDHeader = "X-Request-ID"
+
+// (private) maximum length of a request ID received from a client
+const maxRequestIDLength = 128
+
+// log formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// New returns a logger that writes to `writer` the records of at least the
+// given level (debug, info, warn or error) in the given format (FormatJSON or
+// FormatText). Empty values default to info and FormatJSON. Records logged
+// with a context that has a request ID include it as `request_id`
+func New(writer io.Writer, level, format string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if len(level) > 0 {
+		if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level: %s", level)
+		}
+	}
+	options := &slog.HandlerOptions{Level: minLevel}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		handler = slog.NewJSONHandler(writer, options)
+	case FormatText:
+		handler = slog.NewTextHandler(writer, options)
+	default:
+		return nil, fmt.Errorf("invalid log format: %s", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// (private) requestIDKey is the key for the request ID in a context
+type requestIDKey struct{}
+
+// WithRequestID returns a context that carries the given request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by the context, or an empty
+// string if none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// ValidRequestID returns if a request ID received from a client can be used,
+// that is, it's not too long and only has printable ASCII characters
+func ValidRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// (private) contextHandler adds the request ID of the context to the records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (handler contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); len(id) > 0 {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return handler.Handler.Handle(ctx, record)
+}
+
+func (handler contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{handler.Handler.WithAttrs(attrs)}
+}
+
+func (handler contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{handler.Handler.WithGroup(name)}
+}
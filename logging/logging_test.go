@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONRecords(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := New(&buffer, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithRequestID(context.Background(), "abc")
+	logger.InfoContext(ctx, "Processed request", "results", 10)
+	logger.With("component", "test").WarnContext(ctx, "Slow")
+	logger.Info("No request")
+	logger.Debug("Hidden")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 records, got:\n%s", buffer.String())
+	}
+	var records []map[string]interface{}
+	for _, line := range lines {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid record %s: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if records[0]["msg"] != "Processed request" || records[0]["level"] != "INFO" ||
+		records[0]["request_id"] != "abc" || records[0]["results"] != 10.0 {
+		t.Fatalf("unexpected record: %v", records[0])
+	}
+	if records[1]["component"] != "test" || records[1]["request_id"] != "abc" {
+		t.Fatalf("unexpected record: %v", records[1])
+	}
+	if _, found := records[2]["request_id"]; found {
+		t.Fatalf("unexpected request ID: %v", records[2])
+	}
+}
+
+func TestLevelsAndFormats(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := New(&buffer, "DEBUG", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.DebugContext(WithRequestID(context.Background(), "abc"), "Visible")
+	if output := buffer.String(); !strings.Contains(output, "level=DEBUG msg=Visible request_id=abc") {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	buffer.Reset()
+	if logger, err = New(&buffer, "error", "json"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Warn("Hidden")
+	if buffer.Len() != 0 {
+		t.Fatalf("unexpected output: %s", buffer.String())
+	}
+
+	if _, err := New(&buffer, "verbose", ""); err == nil {
+		t.Fatal("expected an error for the level")
+	}
+	if _, err := New(&buffer, "", "xml"); err == nil {
+		t.Fatal("expected an error for the format")
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	if id := RequestID(context.Background()); id != "" {
+		t.Fatalf("unexpected request ID: %s", id)
+	}
+	first, second := NewRequestID(), NewRequestID()
+	if first == second || !ValidRequestID(first) {
+		t.Fatalf("expected unique valid IDs, got %s and %s", first, second)
+	}
+	for id, valid := range map[string]bool{
+		"a1b2-c3d4":              true,
+		"":                       false,
+		"with space":             false,
+		"line\nbreak":            false,
+		"ñ":                      false,
+		strings.Repeat("a", 128): true,
+		strings.Repeat("a", 129): false,
+	} {
+		if ValidRequestID(id) != valid {
+			t.Fatalf("%q: expected valid=%v", id, valid)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Package metrics implements a minimal registry of metrics that is exposed
+// in the Prometheus text format, so that the service can be monitored
+// without external dependencies
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets suitable for request latencies, in
+// seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ContentType is the content type of the exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metric types
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// (private) collector is implemented by every type of metric
+type collector interface {
+	// write writes the samples of the metric
+	write(writer *bufio.Writer)
+}
+
+// (private) family is the metadata shared by all the series of a metric
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// (private) writeHeader writes the HELP and TYPE lines of the metric
+func (family *family) writeHeader(writer *bufio.Writer) {
+	fmt.Fprintf(writer, "# HELP %s %s\n", family.name, escapeHelp(family.help))
+	fmt.Fprintf(writer, "# TYPE %s %s\n", family.name, family.kind)
+}
+
+// (private) writeSample writes a sample of a metric with the given label
+// names and values
+func writeSample(writer *bufio.Writer, name string, labels, values []string, value float64) {
+	writer.WriteString(name)
+	if len(labels) > 0 {
+		writer.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				writer.WriteByte(',')
+			}
+			fmt.Fprintf(writer, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		writer.WriteByte('}')
+	}
+	writer.WriteByte(' ')
+	writer.WriteString(formatFloat(value))
+	writer.WriteByte('\n')
+}
+
+// (private) series is the state of a metric for a combination of label
+// values
+type series struct {
+	values []string
+	// counter or gauge value, or histogram sum
+	value float64
+	// histogram cumulative bucket counts, excluding +Inf
+	buckets []uint64
+	count   uint64
+}
+
+// (private) vector keeps the series of a metric by their label values
+type vector struct {
+	family
+	mutex  sync.Mutex
+	series map[string]*series
+	// upper bounds of the buckets for histograms
+	bounds []float64
+}
+
+func newVector(name, help, kind string, labels []string) *vector {
+	return &vector{
+		family: family{name, help, kind, labels},
+		series: make(map[string]*series),
+	}
+}
+
+// (private) get returns the series for the given label values, creating it
+// if needed. Must be called with the mutex held
+func (vector *vector) get(values []string) *series {
+	if len(values) != len(vector.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d",
+			vector.name, len(vector.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, found := vector.series[key]
+	if !found {
+		s = &series{values: append([]string(nil), values...)}
+		if vector.bounds != nil {
+			s.buckets = make([]uint64, len(vector.bounds))
+		}
+		vector.series[key] = s
+	}
+	return s
+}
+
+// (private) write writes the series sorted by their label values, so that
+// the output is stable
+func (vector *vector) write(writer *bufio.Writer) {
+	vector.mutex.Lock()
+	defer vector.mutex.Unlock()
+	vector.writeHeader(writer)
+	keys := make([]string, 0, len(vector.series))
+	for key := range vector.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := vector.series[key]
+		if vector.kind != typeHistogram {
+			writeSample(writer, vector.name, vector.labels, s.values, s.value)
+			continue
+		}
+		// buckets have an additional label with their upper bound
+		labels := append(vector.labels[:len(vector.labels):len(vector.labels)], "le")
+		values := append(s.values[:len(s.values):len(s.values)], "")
+		for i, bound := range vector.bounds {
+			values[len(values)-1] = formatFloat(bound)
+			writeSample(writer, vector.name+"_bucket", labels, values, float64(s.buckets[i]))
+		}
+		values[len(values)-1] = "+Inf"
+		writeSample(writer, vector.name+"_bucket", labels, values, float64(s.count))
+		writeSample(writer, vector.name+"_sum", vector.labels, s.values, s.value)
+		writeSample(writer, vector.name+"_count", vector.labels, s.values, float64(s.count))
+	}
+}
+
+// Counter is a metric whose value only increases, with a series for every
+// combination of label values
+type Counter struct {
+	vector *vector
+}
+
+// Inc increments the counter for the given label values by one
+func (counter *Counter) Inc(values ...string) {
+	counter.Add(1, values...)
+}
+
+// Add increments the counter for the given label values. Negative values
+// are ignored
+func (counter *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	counter.vector.mutex.Lock()
+	defer counter.vector.mutex.Unlock()
+	counter.vector.get(values).value += delta
+}
+
+// Gauge is a metric whose value can go up and down, with a series for every
+// combination of label values
+type Gauge struct {
+	vector *vector
+}
+
+// Set sets the value of the gauge for the given label values
+func (gauge *Gauge) Set(value float64, values ...string) {
+	gauge.vector.mutex.Lock()
+	defer gauge.vector.mutex.Unlock()
+	gauge.vector.get(values).value = value
+}
+
+// Add adds to the value of the gauge for the given label values, which
+// can be negative
+func (gauge *Gauge) Add(delta float64, values ...string) {
+	gauge.vector.mutex.Lock()
+	defer gauge.vector.mutex.Unlock()
+	gauge.vector.get(values).value += delta
+}
+
+// Histogram counts observations in buckets, with a series for every
+// combination of label values
+type Histogram struct {
+	vector *vector
+}
+
+// Observe records a value for the given label values
+func (histogram *Histogram) Observe(value float64, values ...string) {
+	histogram.vector.mutex.Lock()
+	defer histogram.vector.mutex.Unlock()
+	s := histogram.vector.get(values)
+	for i, bound := range histogram.vector.bounds {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+// (private) function is a metric without labels whose value is obtained
+// when collected
+type function struct {
+	family
+	value func() float64
+}
+
+func (function *function) write(writer *bufio.Writer) {
+	function.writeHeader(writer)
+	writeSample(writer, function.name, nil, nil, function.value())
+}
+
+// Registry keeps a set of metrics and exposes them. It implements
+// http.Handler to serve them in the Prometheus text format
+type Registry struct {
+	mutex      sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// (private) register adds a metric to the registry. Panics if the name is
+// already registered, as that is a programming error
+func (registry *Registry) register(name string, collector collector) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.names[name] {
+		panic("metric already registered: " + name)
+	}
+	registry.names[name] = true
+	registry.collectors = append(registry.collectors, collector)
+}
+
+// NewCounter registers a counter with the given label names
+func (registry *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	vector := newVector(name, help, typeCounter, labels)
+	registry.register(name, vector)
+	return &Counter{vector}
+}
+
+// NewGauge registers a gauge with the given label names
+func (registry *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	vector := newVector(name, help, typeGauge, labels)
+	registry.register(name, vector)
+	return &Gauge{vector}
+}
+
+// NewHistogram registers a histogram with the given upper bounds for its
+// buckets, in increasing order, and label names
+func (registry *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	vector := newVector(name, help, typeHistogram, labels)
+	vector.bounds = append([]float64(nil), buckets...)
+	sort.Float64s(vector.bounds)
+	registry.register(name, vector)
+	return &Histogram{vector}
+}
+
+// NewCounterFunc registers a counter without labels whose value is obtained
+// from `value` every time it's collected
+func (registry *Registry) NewCounterFunc(name, help string, value func() float64) {
+	registry.register(name, &function{family{name, help, typeCounter, nil}, value})
+}
+
+// NewGaugeFunc registers a gauge without labels whose value is obtained
+// from `value` every time it's collected
+func (registry *Registry) NewGaugeFunc(name, help string, value func() float64) {
+	registry.register(name, &function{family{name, help, typeGauge, nil}, value})
+}
+
+// Write writes all the metrics in the Prometheus text format, in the order
+// they were registered
+func (registry *Registry) Write(writer io.Writer) error {
+	registry.mutex.Lock()
+	collectors := append([]collector(nil), registry.collectors...)
+	registry.mutex.Unlock()
+	buffered := bufio.NewWriter(writer)
+	for _, collector := range collectors {
+		collector.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (registry *Registry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", ContentType)
+	registry.Write(writer)
+}
+
+// (private) formatFloat formats a sample value as expected by Prometheus
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// (private) escapeHelp escapes the help text of a metric
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+// (private) escapeLabel escapes a label value
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func scrape(t *testing.T, registry *Registry) string {
+	var buffer bytes.Buffer
+	if err := registry.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounter("requests_total", "Requests served.", "path", "code")
+	inFlight := registry.NewGauge("in_flight", "Requests in progress.")
+	requests.Inc("/b", "200")
+	requests.Inc("/a", "404")
+	requests.Add(2, "/a", "404")
+	requests.Add(-1, "/a", "404")
+	inFlight.Add(3)
+	inFlight.Add(-1)
+
+	expected := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{path="/a",code="404"} 3
+requests_total{path="/b",code="200"} 1
+# HELP in_flight Requests in progress.
+# TYPE in_flight gauge
+in_flight 2
+`
+	if actual := scrape(t, registry); actual != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+	inFlight.Set(7)
+	if actual := scrape(t, registry); !bytes.Contains([]byte(actual), []byte("\nin_flight 7\n")) {
+		t.Fatalf("gauge not set:\n%s", actual)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	registry := NewRegistry()
+	latency := registry.NewHistogram("latency_seconds", "Request latency.", []float64{1, 0.5}, "path")
+	latency.Observe(0.25, "/")
+	latency.Observe(0.75, "/")
+	latency.Observe(2, "/")
+
+	expected := `# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/",le="0.5"} 1
+latency_seconds_bucket{path="/",le="1"} 2
+latency_seconds_bucket{path="/",le="+Inf"} 3
+latency_seconds_sum{path="/"} 3
+latency_seconds_count{path="/"} 3
+`
+	if actual := scrape(t, registry); actual != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestFunctionsAndEscaping(t *testing.T) {
+	registry := NewRegistry()
+	value := 1.5
+	registry.NewGaugeFunc("value", "A value\nwith a \\ newline.", func() float64 { return value })
+	registry.NewCounterFunc("hits_total", "Hits.", func() float64 { return 4 })
+	errors := registry.NewCounter("errors_total", "Errors.", "message")
+	errors.Inc("say \"hi\"\n")
+	value = 2.5
+
+	expected := `# HELP value A value\nwith a \\ newline.
+# TYPE value gauge
+value 2.5
+# HELP hits_total Hits.
+# TYPE hits_total counter
+hits_total 4
+# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total{message="say \"hi\"\n"} 1
+`
+	if actual := scrape(t, registry); actual != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("total", "Total.", "label")
+	for name, fn := range map[string]func(){
+		"duplicate name":     func() { registry.NewGauge("total", "Total.") },
+		"wrong label values": func() { counter.Inc("a", "b") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s: expected a panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("total", "Total.").Inc()
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(recorder.Body)
+	if recorder.Header().Get("Content-Type") != ContentType || !bytes.HasSuffix(body, []byte("\ntotal 1\n")) {
+		t.Fatalf("unexpected response: %v %s", recorder.Header(), body)
+	}
+}
@@ -2,18 +2,237 @@
 // relationships between packages in the project
 package model
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxContributors is the maximum number of results that can be requested
+// for a single location, as GitHub search API doesn't provide more than
+// 1000 results for any given query
+const MaxContributors = 1000
+
+// DateFormat is the layout used for dates in queries
+const DateFormat = "2006-01-02"
+
 // User is the representation of a GitHub
 // user, already prepared to be serialised
 // to json
 type User struct {
-	ID       int64  `json:"id"`
-	Username string `json:"name"`
+	ID        int64   `json:"id" xml:"id"`
+	Username  string  `json:"name" xml:"name"`
+	AvatarURL string  `json:"avatar_url,omitempty" xml:"avatar_url,omitempty"`
+	HTMLURL   string  `json:"html_url,omitempty" xml:"html_url,omitempty"`
+	Type      string  `json:"type,omitempty" xml:"type,omitempty"`
+	Score     float64 `json:"score,omitempty" xml:"score,omitempty"`
+	// only available when requested in the Query
+	Profile *Profile `json:"profile,omitempty" xml:"profile,omitempty"`
+}
+
+// Ranking is the result of a query: the top users matching it, in order
+type Ranking struct {
+	Users []User `json:"users"`
+	// number of users matching the query according to GitHub
+	TotalCount int `json:"total_count"`
+	// set when GitHub couldn't provide all the results, so the ranking is
+	// not authoritative
+	Incomplete bool `json:"incomplete"`
+	// when the ranking was obtained from GitHub
+	FetchedAt time.Time `json:"fetched_at"`
+	// set when the ranking was served from a cache
+	Cached bool `json:"-"`
+	// set when the ranking was served from a cache after expiring
+	Stale bool `json:"-"`
+}
+
+// Profile contains the details of a user that are not available from the
+// search results
+type Profile struct {
+	Name        string    `json:"name" xml:"name"`
+	Company     string    `json:"company" xml:"company"`
+	Blog        string    `json:"blog" xml:"blog"`
+	Followers   int       `json:"followers" xml:"followers"`
+	PublicRepos int       `json:"public_repos" xml:"public_repos"`
+	CreatedAt   time.Time `json:"created_at" xml:"created_at"`
+}
+
+// ranking criteria for Query.Sort
+const (
+	SortFollowers    = "followers"
+	SortRepositories = "repositories"
+	SortJoined       = "joined"
+)
+
+// ranking orders for Query.Order
+const (
+	OrderDesc = "desc"
+	OrderAsc  = "asc"
+)
+
+// account types for Qualifiers.Type
+const (
+	TypeUser = "user"
+	TypeOrg  = "org"
+)
+
+// IntRange is an inclusive range of integers. Nil bounds are unbounded
+type IntRange struct {
+	Min *int
+	Max *int
+}
+
+// DateRange is an inclusive range of dates. Zero bounds are unbounded
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// Qualifiers restrict the users that are considered for a ranking, besides
+// their location. The zero value doesn't restrict anything
+type Qualifiers struct {
+	// main programming language of the user's repositories
+	Language string
+	// number of followers
+	Followers IntRange
+	// number of public repositories
+	Repos IntRange
+	// account creation date
+	Created DateRange
+	// account type, one of the Type* constants, or empty for any
+	Type string
+}
+
+// Query describes a request for the top contributors in a location
+type Query struct {
+	Location string
+	// maximum number of users to return
+	Count int
+	// ranking criterion, one of the Sort* constants. SortRepositories if
+	// empty
+	Sort string
+	// ranking order, one of the Order* constants. OrderDesc if empty
+	Order string
+	// fetch the Profile of every user, which requires an additional
+	// request to GitHub API per user
+	Profile bool
+	// additional search restrictions
+	Qualifiers
+}
+
+// SortOrder returns the ranking criterion and order of the query, applying
+// the defaults for empty values
+func (query Query) SortOrder() (string, string) {
+	sort, order := query.Sort, query.Order
+	if len(sort) == 0 {
+		sort = SortRepositories
+	}
+	if len(order) == 0 {
+		order = OrderDesc
+	}
+	return sort, order
+}
+
+// Key returns a canonical representation of the query, excluding the
+// count, so that equivalent queries can be identified
+func (query Query) Key() string {
+	sort, order := query.SortOrder()
+	key := NormalizeLocation(query.Location) + "|" + sort + "|" + order
+	if query.Profile {
+		key += "|profile"
+	}
+	return key + "|" + query.Qualifiers.key()
+}
+
+// Validate checks that the qualifiers describe a valid search
+func (qualifiers Qualifiers) Validate() error {
+	if strings.ContainsAny(qualifiers.Language, "\"\x00\r\n") {
+		return errors.New("language contains invalid characters")
+	}
+	if err := qualifiers.Followers.validate("followers"); err != nil {
+		return err
+	}
+	if err := qualifiers.Repos.validate("repos"); err != nil {
+		return err
+	}
+	created := qualifiers.Created
+	if !created.From.IsZero() && !created.To.IsZero() && created.To.Before(created.From) {
+		return errors.New("created range is empty")
+	}
+	if len(qualifiers.Type) > 0 && qualifiers.Type != TypeUser && qualifiers.Type != TypeOrg {
+		return errors.New("type must be user or org")
+	}
+	return nil
+}
+
+// (private) key returns a canonical representation of the qualifiers
+func (qualifiers Qualifiers) key() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s",
+		strings.ToLower(qualifiers.Language),
+		qualifiers.Followers, qualifiers.Repos, qualifiers.Created,
+		qualifiers.Type)
+}
+
+// String returns a representation of the range as min..max, with * for an
+// unbounded side
+func (r IntRange) String() string {
+	bound := func(value *int) string {
+		if value == nil {
+			return "*"
+		}
+		return strconv.Itoa(*value)
+	}
+	return bound(r.Min) + ".." + bound(r.Max)
+}
+
+// (private) validate checks that the range is not empty nor negative
+func (r IntRange) validate(name string) error {
+	if (r.Min != nil && *r.Min < 0) || (r.Max != nil && *r.Max < 0) {
+		return fmt.Errorf("%s range can't be negative", name)
+	}
+	if r.Min != nil && r.Max != nil && *r.Max < *r.Min {
+		return fmt.Errorf("%s range is empty", name)
+	}
+	return nil
+}
+
+// String returns a representation of the range as from..to, with * for an
+// unbounded side
+func (r DateRange) String() string {
+	bound := func(value time.Time) string {
+		if value.IsZero() {
+			return "*"
+		}
+		return value.Format(DateFormat)
+	}
+	return bound(r.From) + ".." + bound(r.To)
+}
+
+// ValidSort returns if the given ranking criterion is supported
+func ValidSort(sort string) bool {
+	return sort == SortFollowers || sort == SortRepositories || sort == SortJoined
+}
+
+// ValidOrder returns if the given ranking order is supported
+func ValidOrder(order string) bool {
+	return order == OrderDesc || order == OrderAsc
 }
 
 // TopContributorGetter is an interface for a type that implements
 // the GetTopContributors method
 type TopContributorGetter interface {
-	// GetTopContributors returns a list of the top `count` contributors
-	// in a given `location`
-	GetTopContributors(location string, count int) ([]User, error)
+	// GetTopContributors returns a ranking of the top `query.Count`
+	// contributors matching the query. The query is aborted when the
+	// context is cancelled
+	GetTopContributors(ctx context.Context, query Query) (*Ranking, error)
+}
+
+// NormalizeLocation converts a location into a canonical form, so that
+// queries that only differ in case or whitespace can be identified as
+// equivalent, as GitHub search is case insensitive
+func NormalizeLocation(location string) string {
+	return strings.ToLower(strings.Join(strings.Fields(location), " "))
 }
@@ -0,0 +1,134 @@
+// Package pool implements a fixed pool of workers that serialises access to
+// a TopContributorGetter, so that the number of concurrent queries to GitHub
+// API is bounded regardless of the number of requests to the service
+package pool
+
+import (
+	"context"
+	"sync"
+
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// Budget is implemented by types that keep track of a rate limit, like
+// githubapi.Client
+type Budget interface {
+	// AwaitQuota blocks until there is quota available to perform a query,
+	// or returns an error if that is not possible
+	AwaitQuota(ctx context.Context) error
+}
+
+// Pool is a TopContributorGetter that queues the queries and processes them
+// with a fixed number of workers. Queries are rejected when the queue is full
+type Pool struct {
+	getter model.TopContributorGetter
+	budget Budget
+	jobs   chan *job
+
+	// protects closed and the sending side of jobs
+	mutex   sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// (private) job represents a query waiting to be processed
+type job struct {
+	ctx    context.Context
+	query  model.Query
+	result chan result
+}
+
+// (private) result of a processed job
+type result struct {
+	ranking *model.Ranking
+	err     error
+}
+
+// New creates a Pool that forwards the queries to `getter` using `workers`
+// goroutines, with up to `queueSize` queries waiting. If budget is not nil,
+// workers wait for available quota before processing each query
+func New(getter model.TopContributorGetter, budget Budget, workers, queueSize int) *Pool {
+	pool := &Pool{
+		getter: getter,
+		budget: budget,
+		jobs:   make(chan *job, queueSize),
+	}
+	pool.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+// GetTopContributors queues the query and waits for a worker to process it.
+// Returns util.ErrQueueFull if the queue is full
+func (pool *Pool) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	job := &job{ctx, query, make(chan result, 1)}
+	if err := pool.enqueue(job); err != nil {
+		return nil, err
+	}
+	select {
+	case result := <-job.result:
+		return result.ranking, result.err
+	case <-ctx.Done():
+		// the worker will discard the job or abort it as its context
+		// is already cancelled
+		return nil, ctx.Err()
+	}
+}
+
+// Pending returns the number of queries waiting in the queue
+func (pool *Pool) Pending() int {
+	return len(pool.jobs)
+}
+
+// Close stops accepting queries and waits for the queued ones to be
+// processed
+func (pool *Pool) Close() {
+	pool.mutex.Lock()
+	if !pool.closed {
+		pool.closed = true
+		close(pool.jobs)
+	}
+	pool.mutex.Unlock()
+	pool.workers.Wait()
+}
+
+// (private) enqueue adds a job to the queue without blocking
+func (pool *Pool) enqueue(job *job) error {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	if pool.closed {
+		return util.NewErrorKind(util.Overloaded, "worker pool is closed")
+	}
+	select {
+	case pool.jobs <- job:
+		return nil
+	default:
+		return util.ErrQueueFull
+	}
+}
+
+// (private) work is the main loop of a worker goroutine
+func (pool *Pool) work() {
+	defer pool.workers.Done()
+	for job := range pool.jobs {
+		job.result <- pool.process(job)
+	}
+}
+
+// (private) process runs a single query, after waiting for quota
+func (pool *Pool) process(job *job) result {
+	if err := job.ctx.Err(); err != nil {
+		// abandoned while queued
+		return result{nil, err}
+	}
+	if pool.budget != nil {
+		if err := pool.budget.AwaitQuota(job.ctx); err != nil {
+			return result{nil, err}
+		}
+	}
+	ranking, err := pool.getter.GetTopContributors(job.ctx, job.query)
+	return result{ranking, err}
+}
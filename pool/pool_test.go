@@ -0,0 +1,193 @@
+package pool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// Tracker helper that records the number of concurrent calls to the
+// TopContributorGetter interface
+type Tracker struct {
+	Delay   time.Duration
+	active  int32
+	maxSeen int32
+	calls   int32
+}
+
+func (tracker *Tracker) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	atomic.AddInt32(&tracker.calls, 1)
+	active := atomic.AddInt32(&tracker.active, 1)
+	defer atomic.AddInt32(&tracker.active, -1)
+	for {
+		seen := atomic.LoadInt32(&tracker.maxSeen)
+		if active <= seen || atomic.CompareAndSwapInt32(&tracker.maxSeen, seen, active) {
+			break
+		}
+	}
+	time.Sleep(tracker.Delay)
+	return &model.Ranking{Users: make([]model.User, query.Count)}, nil
+}
+
+// Gate helper that blocks every query until released
+type Gate struct {
+	Started chan struct{}
+	Release chan struct{}
+}
+
+func (gate *Gate) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	gate.Started <- struct{}{}
+	<-gate.Release
+	return nil, nil
+}
+
+// FakeBudget helper that fails with the given error
+type FakeBudget struct {
+	Error error
+	Calls int32
+}
+
+func (budget *FakeBudget) AwaitQuota(ctx context.Context) error {
+	atomic.AddInt32(&budget.Calls, 1)
+	return budget.Error
+}
+
+func TestConcurrencyBound(t *testing.T) {
+	const workers, queries = 3, 60
+	tracker := &Tracker{Delay: 5 * time.Millisecond}
+	pool := New(tracker, nil, workers, queries)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	errors := make(chan error, queries)
+	for i := 0; i < queries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err != nil {
+				errors <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errors)
+	for err := range errors {
+		t.Fatal(err)
+	}
+	if tracker.calls != queries {
+		t.Fatalf("expected %d calls, got %d", queries, tracker.calls)
+	}
+	if tracker.maxSeen > workers {
+		t.Fatalf("concurrency bound exceeded: %d concurrent calls", tracker.maxSeen)
+	}
+	if tracker.maxSeen < 2 {
+		t.Fatalf("workers not running concurrently: %d", tracker.maxSeen)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	gate := &Gate{make(chan struct{}), make(chan struct{})}
+	pool := New(gate, nil, 1, 1)
+	defer pool.Close()
+
+	results := make(chan error, 2)
+	query := func() {
+		_, err := pool.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+		results <- err
+	}
+	// first query is processed by the only worker
+	go query()
+	<-gate.Started
+	// second one waits in the queue
+	go query()
+	for pool.Pending() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// third one is rejected
+	if _, err := pool.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err != util.ErrQueueFull {
+		t.Fatalf("expected queue full error, got %v", err)
+	}
+
+	close(gate.Release)
+	<-gate.Started
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	tracker := &Tracker{}
+	budget := &FakeBudget{}
+	pool := New(tracker, budget, 2, 10)
+	defer pool.Close()
+
+	if _, err := pool.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err != nil {
+		t.Fatal(err)
+	}
+	budget.Error = util.NewRateLimitError("limited", time.Minute)
+	if _, err := pool.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err != budget.Error {
+		t.Fatalf("expected budget error, got %v", err)
+	}
+	if budget.Calls != 2 {
+		t.Fatalf("two budget checks expected, got %d", budget.Calls)
+	}
+	if tracker.calls != 1 {
+		t.Fatalf("one query expected, got %d", tracker.calls)
+	}
+}
+
+func TestCancelledWhileQueued(t *testing.T) {
+	gate := &Gate{make(chan struct{}), make(chan struct{})}
+	pool := New(gate, nil, 1, 10)
+	defer pool.Close()
+
+	go pool.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	<-gate.Started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := pool.GetTopContributors(ctx, model.Query{Location: "Madrid", Count: 50})
+		result <- err
+	}()
+	for pool.Pending() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	// the abandoned query never reaches the getter
+	close(gate.Release)
+	select {
+	case <-gate.Started:
+		t.Fatal("cancelled query was processed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClose(t *testing.T) {
+	tracker := &Tracker{Delay: 10 * time.Millisecond}
+	pool := New(tracker, nil, 1, 10)
+
+	for i := 0; i < 3; i++ {
+		go pool.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50})
+	}
+	for atomic.LoadInt32(&tracker.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	pool.Close()
+	if pool.Pending() != 0 {
+		t.Fatalf("queue not drained: %d pending", pool.Pending())
+	}
+	if _, err := pool.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 50}); err == nil {
+		t.Fatal("failure expected after close")
+	}
+}
@@ -0,0 +1,274 @@
+// Package scheduler refreshes the rankings of watched locations in the
+// background, so that the queries for popular locations are answered from
+// the cache instead of waiting for GitHub API
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/adriansr/github-api-service/githubapi"
+	"github.com/adriansr/github-api-service/logging"
+	"github.com/adriansr/github-api-service/model"
+)
+
+// RefreshFunc fetches the ranking for a query, like cache.Cache.Refresh
+type RefreshFunc func(ctx context.Context, query model.Query) (*model.Ranking, error)
+
+// Budget is implemented by types that report the rate limit quota, like
+// githubapi.Client
+type Budget interface {
+	RateLimit() githubapi.RateLimitStatus
+}
+
+// Watch is a query that is refreshed periodically
+type Watch struct {
+	Query model.Query
+	// time between refreshes
+	Interval time.Duration
+}
+
+// Result of the last run of a watch
+type Result string
+
+// run results
+const (
+	// the ranking was refreshed
+	ResultOK Result = "ok"
+	// the query failed
+	ResultFailed Result = "failed"
+	// the run was postponed to preserve the rate limit quota
+	ResultSkipped Result = "skipped"
+)
+
+// Status is a snapshot of the state of a watch
+type Status struct {
+	City     string `json:"city"`
+	Count    int    `json:"count"`
+	Sort     string `json:"sort"`
+	Order    string `json:"order"`
+	Interval string `json:"interval"`
+	// when the watch is run next
+	NextRun time.Time `json:"next_run"`
+	// when the last run started, if any
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	LastResult Result     `json:"last_result,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	// number of users obtained in the last successful run
+	Users int `json:"users"`
+	// set when the last ranking obtained was incomplete, so it wasn't cached
+	Incomplete bool `json:"incomplete"`
+	Runs       int  `json:"runs"`
+	Failures   int  `json:"failures"`
+}
+
+// (private) watch is the internal state of a Watch
+type watch struct {
+	Watch
+	status Status
+}
+
+// Scheduler runs the watches one at a time, each one after its interval
+// plus or minus a random jitter, so that watches with the same interval
+// don't hit GitHub API at the same time. Runs are postponed while the
+// remaining rate limit quota is below a reserve kept for other queries
+type Scheduler struct {
+	refresh RefreshFunc
+	budget  Budget
+	// requests of quota not used by the watches
+	reserve int
+	// fraction of the interval randomly added or subtracted
+	jitter float64
+
+	// protects the status of the watches
+	mutex   sync.Mutex
+	watches []*watch
+
+	// stops the scheduler when closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// (private) sources of time and randomness, replaceable for testing
+	now    func() time.Time
+	random func() float64
+}
+
+// New returns a Scheduler that refreshes the given watches with `refresh`.
+// If budget is not nil, runs are postponed until the quota is reset when
+// less than `reserve` requests remain. The scheduler is idle until started
+func New(refresh RefreshFunc, budget Budget, reserve int, jitter float64, watches []Watch) (*Scheduler, error) {
+	if jitter < 0 || jitter >= 1 {
+		return nil, errors.New("jitter must be in the [0, 1) range")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler := &Scheduler{
+		refresh: refresh,
+		budget:  budget,
+		reserve: reserve,
+		jitter:  jitter,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		now:     time.Now,
+		random:  rand.Float64,
+	}
+	for i, w := range watches {
+		if err := validate(w); err != nil {
+			cancel()
+			return nil, fmt.Errorf("watch %d (%s): %w", i, w.Query.Location, err)
+		}
+		sort, order := w.Query.SortOrder()
+		scheduler.watches = append(scheduler.watches, &watch{w, Status{
+			City:     w.Query.Location,
+			Count:    w.Query.Count,
+			Sort:     sort,
+			Order:    order,
+			Interval: w.Interval.String(),
+		}})
+	}
+	return scheduler, nil
+}
+
+// (private) validate checks that a watch can be run
+func validate(w Watch) error {
+	query := w.Query
+	if len(model.NormalizeLocation(query.Location)) == 0 {
+		return errors.New("missing location")
+	}
+	if query.Count < 1 || query.Count > model.MaxContributors {
+		return fmt.Errorf("count must be between 1 and %d", model.MaxContributors)
+	}
+	if len(query.Sort) > 0 && !model.ValidSort(query.Sort) {
+		return errors.New("invalid sort: " + query.Sort)
+	}
+	if len(query.Order) > 0 && !model.ValidOrder(query.Order) {
+		return errors.New("invalid order: " + query.Order)
+	}
+	if w.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	return nil
+}
+
+// Start runs the watches in the background until the scheduler is closed.
+// The first run of every watch happens within its jitter, so that the
+// rankings are available soon after starting
+func (scheduler *Scheduler) Start() {
+	now := scheduler.now()
+	scheduler.mutex.Lock()
+	for _, w := range scheduler.watches {
+		offset := scheduler.jitter * scheduler.random() * float64(w.Interval)
+		w.status.NextRun = now.Add(time.Duration(offset))
+	}
+	scheduler.mutex.Unlock()
+	go scheduler.loop()
+}
+
+// Close stops the scheduler, aborting the run in progress, if any, and
+// waits for it to finish. Must only be called after Start
+func (scheduler *Scheduler) Close() {
+	scheduler.cancel()
+	<-scheduler.done
+}
+
+// Status returns the status of every watch, in the configured order
+func (scheduler *Scheduler) Status() []Status {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	result := make([]Status, len(scheduler.watches))
+	for i, w := range scheduler.watches {
+		result[i] = w.status
+	}
+	return result
+}
+
+// (private) loop runs the watches as they become due
+func (scheduler *Scheduler) loop() {
+	defer close(scheduler.done)
+	for {
+		next := scheduler.runDue()
+		timer := time.NewTimer(next.Sub(scheduler.now()))
+		select {
+		case <-timer.C:
+		case <-scheduler.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// (private) runDue runs the watches that are due and returns when the next
+// one will be. Without watches, it returns a time far in the future
+func (scheduler *Scheduler) runDue() time.Time {
+	next := scheduler.now().Add(24 * time.Hour)
+	for _, w := range scheduler.watches {
+		if scheduler.ctx.Err() != nil {
+			break
+		}
+		scheduler.mutex.Lock()
+		due := w.status.NextRun
+		scheduler.mutex.Unlock()
+		if !scheduler.now().Before(due) {
+			due = scheduler.run(w)
+		}
+		if due.Before(next) {
+			next = due
+		}
+	}
+	return next
+}
+
+// (private) run refreshes the ranking of a watch, unless the quota is
+// running low, and returns when it must run next
+func (scheduler *Scheduler) run(w *watch) time.Time {
+	started := scheduler.now()
+	// the requests of every run are identified by a new request ID
+	ctx := logging.WithRequestID(scheduler.ctx, logging.NewRequestID())
+	if scheduler.budget != nil {
+		quota := scheduler.budget.RateLimit()
+		if quota.Remaining >= 0 && quota.Remaining < scheduler.reserve && quota.Reset.After(started) {
+			scheduler.mutex.Lock()
+			defer scheduler.mutex.Unlock()
+			w.status.LastResult = ResultSkipped
+			w.status.LastError = fmt.Sprintf("%d requests remaining until %s",
+				quota.Remaining, quota.Reset.Format(time.RFC3339))
+			w.status.NextRun = quota.Reset
+			slog.WarnContext(ctx, "Watch postponed to preserve the rate limit quota",
+				"city", w.Query.Location, "remaining", quota.Remaining, "next_run", quota.Reset)
+			return w.status.NextRun
+		}
+	}
+
+	ranking, err := scheduler.refresh(ctx, w.Query)
+
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	w.status.LastRun = &started
+	w.status.Runs++
+	if err != nil {
+		w.status.Failures++
+		w.status.LastResult = ResultFailed
+		w.status.LastError = err.Error()
+		slog.ErrorContext(ctx, "Watch refresh failed", "city", w.Query.Location, "error", err)
+	} else {
+		w.status.LastResult = ResultOK
+		w.status.LastError = ""
+		w.status.Users = len(ranking.Users)
+		w.status.Incomplete = ranking.Incomplete
+		slog.InfoContext(ctx, "Watch refreshed", "city", w.Query.Location, "users", len(ranking.Users))
+	}
+	w.status.NextRun = scheduler.now().Add(scheduler.delay(w.Interval))
+	return w.status.NextRun
+}
+
+// (private) delay returns the interval with a random jitter applied
+func (scheduler *Scheduler) delay(interval time.Duration) time.Duration {
+	factor := 1 + scheduler.jitter*(2*scheduler.random()-1)
+	return time.Duration(factor * float64(interval))
+}
@@ -0,0 +1,214 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/githubapi"
+	"github.com/adriansr/github-api-service/logging"
+	"github.com/adriansr/github-api-service/model"
+)
+
+// Refresher helper that records the refreshed queries
+type Refresher struct {
+	mutex   sync.Mutex
+	Error   error
+	Queries []model.Query
+	// request IDs of the queries
+	IDs []string
+}
+
+func (refresher *Refresher) Refresh(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	refresher.mutex.Lock()
+	defer refresher.mutex.Unlock()
+	refresher.Queries = append(refresher.Queries, query)
+	refresher.IDs = append(refresher.IDs, logging.RequestID(ctx))
+	if refresher.Error != nil {
+		return nil, refresher.Error
+	}
+	return &model.Ranking{Users: make([]model.User, query.Count)}, nil
+}
+
+func (refresher *Refresher) Calls() int {
+	refresher.mutex.Lock()
+	defer refresher.mutex.Unlock()
+	return len(refresher.Queries)
+}
+
+// Quota helper that implements Budget
+type Quota githubapi.RateLimitStatus
+
+func (quota *Quota) RateLimit() githubapi.RateLimitStatus {
+	return githubapi.RateLimitStatus(*quota)
+}
+
+// Clock helper to control the passing of time
+type Clock struct {
+	current time.Time
+}
+
+func (clock *Clock) now() time.Time {
+	return clock.current
+}
+
+func (clock *Clock) advance(d time.Duration) {
+	clock.current = clock.current.Add(d)
+}
+
+func newScheduler(t *testing.T, refresher *Refresher, budget Budget, watches ...Watch) (*Scheduler, *Clock) {
+	scheduler, err := New(refresher.Refresh, budget, 10, 0.5, watches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &Clock{time.Unix(1500000000, 0)}
+	scheduler.now = clock.now
+	// no randomness: runs happen exactly after their interval, first runs
+	// happen immediately
+	scheduler.random = func() float64 { return 0.5 }
+	for _, w := range scheduler.watches {
+		w.status.NextRun = clock.now()
+	}
+	return scheduler, clock
+}
+
+func TestInvalidWatches(t *testing.T) {
+	valid := model.Query{Location: "Barcelona", Count: 10}
+	for _, tt := range []Watch{
+		{model.Query{Location: " ", Count: 10}, time.Minute},
+		{model.Query{Location: "Barcelona", Count: 0}, time.Minute},
+		{model.Query{Location: "Barcelona", Count: 1001}, time.Minute},
+		{model.Query{Location: "Barcelona", Count: 10, Sort: "stars"}, time.Minute},
+		{model.Query{Location: "Barcelona", Count: 10, Order: "up"}, time.Minute},
+		{valid, 0},
+	} {
+		if _, err := New(nil, nil, 0, 0, []Watch{tt}); err == nil {
+			t.Fatalf("%+v: expected an error", tt)
+		}
+	}
+	if _, err := New(nil, nil, 0, 1, []Watch{{valid, time.Minute}}); err == nil {
+		t.Fatal("expected an error for jitter")
+	}
+}
+
+func TestRunDue(t *testing.T) {
+	refresher := &Refresher{}
+	scheduler, clock := newScheduler(t, refresher, nil,
+		Watch{model.Query{Location: "Barcelona", Count: 10}, time.Minute},
+		Watch{model.Query{Location: "Madrid", Count: 20}, time.Hour})
+
+	next := scheduler.runDue()
+	if refresher.Calls() != 2 || !next.Equal(clock.now().Add(time.Minute)) {
+		t.Fatalf("expected two runs, got %d, next run at %v", refresher.Calls(), next)
+	}
+	clock.advance(59 * time.Second)
+	scheduler.runDue()
+	if refresher.Calls() != 2 {
+		t.Fatalf("no runs expected, got %d", refresher.Calls()-2)
+	}
+	clock.advance(time.Second)
+	scheduler.runDue()
+	if refresher.Calls() != 3 || refresher.Queries[2].Location != "Barcelona" {
+		t.Fatalf("expected a run for Barcelona, got %+v", refresher.Queries)
+	}
+	// every run has its own request ID
+	if ids := refresher.IDs; len(ids[0]) == 0 || ids[0] == ids[1] || ids[1] == ids[2] {
+		t.Fatalf("expected distinct request IDs, got %v", ids)
+	}
+
+	status := scheduler.Status()
+	if status[0].Runs != 2 || status[0].LastResult != ResultOK || status[0].Users != 10 ||
+		!status[0].NextRun.Equal(clock.now().Add(time.Minute)) {
+		t.Fatalf("unexpected status: %+v", status[0])
+	}
+	if status[1].Runs != 1 || status[1].Sort != model.SortRepositories || status[1].Interval != "1h0m0s" {
+		t.Fatalf("unexpected status: %+v", status[1])
+	}
+}
+
+func TestJitter(t *testing.T) {
+	refresher := &Refresher{}
+	scheduler, clock := newScheduler(t, refresher, nil,
+		Watch{model.Query{Location: "Barcelona", Count: 10}, time.Minute})
+
+	for _, tt := range []struct {
+		random   float64
+		expected time.Duration
+	}{
+		{0, 30 * time.Second},
+		{0.5, time.Minute},
+		{1, 90 * time.Second},
+	} {
+		random := tt.random
+		scheduler.random = func() float64 { return random }
+		clock.advance(2 * time.Minute)
+		if next := scheduler.runDue(); next.Sub(clock.now()) != tt.expected {
+			t.Fatalf("random %v: expected next run in %v, got %v", random, tt.expected, next.Sub(clock.now()))
+		}
+	}
+}
+
+func TestFailedRun(t *testing.T) {
+	refresher := &Refresher{Error: errors.New("boom")}
+	scheduler, clock := newScheduler(t, refresher, nil,
+		Watch{model.Query{Location: "Barcelona", Count: 10}, time.Minute})
+
+	next := scheduler.runDue()
+	status := scheduler.Status()[0]
+	if status.LastResult != ResultFailed || status.LastError != "boom" || status.Failures != 1 ||
+		!next.Equal(clock.now().Add(time.Minute)) {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestRateLimitReserve(t *testing.T) {
+	refresher := &Refresher{}
+	quota := &Quota{Remaining: 9}
+	scheduler, clock := newScheduler(t, refresher, quota,
+		Watch{model.Query{Location: "Barcelona", Count: 10}, time.Hour})
+	quota.Reset = clock.now().Add(time.Minute)
+
+	next := scheduler.runDue()
+	status := scheduler.Status()[0]
+	if refresher.Calls() != 0 || status.LastResult != ResultSkipped || !next.Equal(quota.Reset) {
+		t.Fatalf("expected the run to be postponed, got %d runs, %+v", refresher.Calls(), status)
+	}
+	// the quota is assumed to be restored after the reset time
+	clock.advance(time.Minute)
+	scheduler.runDue()
+	if refresher.Calls() != 1 {
+		t.Fatalf("one run expected, got %d", refresher.Calls())
+	}
+	// unknown quota doesn't prevent runs
+	quota.Remaining, quota.Reset = -1, clock.now().Add(time.Hour)
+	clock.advance(time.Hour)
+	scheduler.runDue()
+	if refresher.Calls() != 2 {
+		t.Fatalf("two runs expected, got %d", refresher.Calls())
+	}
+}
+
+func TestStartClose(t *testing.T) {
+	refresher := &Refresher{}
+	scheduler, err := New(refresher.Refresh, nil, 0, 0,
+		[]Watch{{model.Query{Location: "Barcelona", Count: 10}, 10 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.Start()
+	deadline := time.Now().Add(time.Second)
+	for refresher.Calls() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	scheduler.Close()
+	if refresher.Calls() < 3 {
+		t.Fatalf("expected periodic runs, got %d", refresher.Calls())
+	}
+	calls := refresher.Calls()
+	time.Sleep(30 * time.Millisecond)
+	if refresher.Calls() != calls {
+		t.Fatal("runs after closing the scheduler")
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/adriansr/github-api-service/deepscan"
+)
+
+const (
+	// path for the deep scan endpoints
+	deepScanPath = "/api/deep-scans"
+	// error code for unknown deep scan jobs
+	codeJobNotFound = "job_not_found"
+)
+
+// EnableDeepScans registers the endpoints to submit deep scans and check
+// their status, which are run by the given manager:
+//
+//	POST /api/deep-scans?city=...  starts a deep scan
+//	GET  /api/deep-scans/{id}      returns the job status and result
+func (server *Server) EnableDeepScans(manager *deepscan.Manager) {
+	server.deepScans = manager
+	server.handler.HandleFunc(deepScanPath, server.submitDeepScan)
+	server.handler.HandleFunc(deepScanPath+"/", server.getDeepScan)
+	slog.Info("Registered API endpoint", "path", deepScanPath)
+}
+
+// (private) submitDeepScan handles requests to start a deep scan. Accepts
+// the same parameters as the top contributors endpoint, except count
+func (server *Server) submitDeepScan(writer http.ResponseWriter, request *http.Request) {
+	setCommonHeaders(writer)
+	if request.Method != "POST" {
+		writer.Header().Add("Allow", "POST")
+		sendError(writer, http.StatusMethodNotAllowed, codeMethodNotAllowed,
+			"only POST requests allowed")
+		return
+	}
+	params := request.URL.Query()
+	params.Del("count")
+	query, paramErr := parseQuery(params)
+	if paramErr != nil {
+		sendError(writer, http.StatusBadRequest, paramErr.code, paramErr.message)
+		return
+	}
+	job, err := server.deepScans.Submit(query)
+	if err != nil {
+		sendQueryError(writer, err)
+		return
+	}
+	writer.Header().Set("Location", deepScanPath+"/"+job.ID)
+	sendObject(writer, http.StatusAccepted, job)
+	slog.InfoContext(request.Context(), "Submitted deep scan", "job_id", job.ID)
+}
+
+// (private) getDeepScan handles requests for the status of a deep scan
+func (server *Server) getDeepScan(writer http.ResponseWriter, request *http.Request) {
+	setCommonHeaders(writer)
+	if request.Method != "GET" {
+		writer.Header().Add("Allow", "GET")
+		sendError(writer, http.StatusMethodNotAllowed, codeMethodNotAllowed,
+			"only GET requests allowed")
+		return
+	}
+	id := strings.TrimPrefix(request.URL.Path, deepScanPath+"/")
+	job, found := server.deepScans.Get(id)
+	if !found {
+		sendError(writer, http.StatusNotFound, codeJobNotFound,
+			"unknown deep scan: "+id)
+		return
+	}
+	sendObject(writer, http.StatusOK, job)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/deepscan"
+	"github.com/adriansr/github-api-service/model"
+)
+
+// Scanner helper that completes deep scans immediately
+type Scanner struct {
+	Query model.Query
+}
+
+func (scanner *Scanner) DeepScan(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	scanner.Query = query
+	return &model.Ranking{Users: []model.User{{ID: 1, Username: "user_1"}}, TotalCount: 1}, nil
+}
+
+func TestDeepScanEndpoints(t *testing.T) {
+	scanner := &Scanner{}
+	manager := deepscan.New(scanner, 1, 10)
+	defer manager.Close()
+	server := createServer(t, newRecorder(0, nil))
+	defer server.stop()
+	server.server.EnableDeepScans(manager)
+
+	client := http.Client{Timeout: time.Second}
+	response, err := client.Post(server.url()+"/api/deep-scans?city=Barcelona&sort=followers&count=5000", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("got HTTP code %d", response.StatusCode)
+	}
+	var job deepscan.Job
+	if err := json.NewDecoder(response.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	location := response.Header.Get("Location")
+	if location != "/api/deep-scans/"+job.ID {
+		t.Fatalf("wrong location: %s", location)
+	}
+
+	for attempt := 0; job.Status != deepscan.StatusDone; attempt++ {
+		if attempt == 100 {
+			t.Fatalf("job not finished: %+v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+		response, err := client.Get(server.url() + location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("got HTTP code %d", response.StatusCode)
+		}
+		if err := json.NewDecoder(response.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job.Result == nil || len(job.Result.Users) != 1 {
+		t.Fatalf("unexpected result: %+v", job.Result)
+	}
+	if scanner.Query.Location != "Barcelona" || scanner.Query.Sort != model.SortFollowers {
+		t.Fatalf("wrong query: %+v", scanner.Query)
+	}
+}
+
+func TestDeepScanErrors(t *testing.T) {
+	manager := deepscan.New(&Scanner{}, 1, 10)
+	defer manager.Close()
+	server := createServer(t, newRecorder(0, nil))
+	defer server.stop()
+	server.server.EnableDeepScans(manager)
+
+	tests := []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{"GET", "/api/deep-scans?city=Barcelona", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"POST", "/api/deep-scans", http.StatusBadRequest, codeMissingParameter},
+		{"POST", "/api/deep-scans?city=Barcelona&sort=stars", http.StatusBadRequest, codeInvalidParameter},
+		{"GET", "/api/deep-scans/unknown", http.StatusNotFound, codeJobNotFound},
+		{"DELETE", "/api/deep-scans/unknown", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+	}
+	client := http.Client{Timeout: time.Second}
+	for _, tt := range tests {
+		request, _ := http.NewRequest(tt.method, fmt.Sprintf("%s%s", server.url(), tt.path), nil)
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var apiError ApiError
+		json.NewDecoder(response.Body).Decode(&apiError)
+		if response.StatusCode != tt.status || apiError.Code != tt.code {
+			t.Fatalf("%s %s: got %d %s", tt.method, tt.path, response.StatusCode, apiError.Code)
+		}
+	}
+}
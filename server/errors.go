@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/adriansr/github-api-service/util"
+)
+
+// ApiError struct is used to represent the error responses from the API.
+// Code is a stable, machine-readable identifier of the error, while Error is
+// a human-readable description
+type ApiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// error codes for ApiError
+const (
+	codeMethodNotAllowed   = "method_not_allowed"
+	codeInvalidParameter   = "invalid_parameter"
+	codeMissingParameter   = "missing_parameter"
+	codeNotAcceptable      = "not_acceptable"
+	codeInternalError      = "internal_error"
+	codeInvalidQuery       = "invalid_query"
+	codeRateLimited        = "rate_limited"
+	codeOverloaded         = "overloaded"
+	codeUpstreamFailure    = "upstream_unavailable"
+	codeUpstreamTimeout    = "upstream_timeout"
+	codeUpstreamBadContent = "upstream_decode_failure"
+)
+
+// (private) errorResponse describes how an error Kind is reported
+type errorResponse struct {
+	status int
+	code   string
+}
+
+// error responses for each Kind of query error
+var queryErrors = map[util.Kind]errorResponse{
+	util.Internal:     {http.StatusInternalServerError, codeInternalError},
+	util.InvalidQuery: {http.StatusBadRequest, codeInvalidQuery},
+	util.RateLimited:  {http.StatusTooManyRequests, codeRateLimited},
+	util.Overloaded:   {http.StatusServiceUnavailable, codeOverloaded},
+	util.Unavailable:  {http.StatusBadGateway, codeUpstreamFailure},
+	util.Timeout:      {http.StatusGatewayTimeout, codeUpstreamTimeout},
+	util.Decode:       {http.StatusBadGateway, codeUpstreamBadContent},
+}
+
+func sendError(writer http.ResponseWriter, status int, code string, msg string) {
+	object := ApiError{msg, code}
+	body, err := json.Marshal(object)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body = []byte(`{"error": "internal error", "code": "internal_error"}`)
+	}
+	// the message is logged along with the request
+	if recorder, ok := writer.(*responseRecorder); ok {
+		recorder.err = msg
+	}
+	writer.WriteHeader(status)
+	writer.Write(body)
+}
+
+// sendQueryError sends the error response for a failed query, with the
+// status and error code that correspond to its Kind. Errors with a retry
+// delay, like those caused by rate limits, include a Retry-After header
+func sendQueryError(writer http.ResponseWriter, err error) {
+	response, found := queryErrors[util.KindOf(err)]
+	if !found {
+		response = queryErrors[util.Internal]
+	}
+	if retryAfter := util.RetryAfter(err); retryAfter > 0 {
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		writer.Header().Set("Retry-After", strconv.Itoa(util.Max(seconds, 1)))
+	}
+	sendError(writer, response.status, response.code, "query failed: "+err.Error())
+}
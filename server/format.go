@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+// (private) format is an output representation for a list of users
+type format struct {
+	// value of the `format` parameter that selects it
+	name string
+	// media type sent in the Content-Type header
+	contentType string
+	// additional media types accepted for it
+	aliases []string
+	// writes the users, `profile` is set when profiles were requested
+	encode func(writer io.Writer, users []model.User, profile bool) error
+}
+
+// supported output formats, the first one is the default
+var formats = []format{
+	{"json", "application/json", nil, encodeJSON},
+	{"csv", "text/csv", nil, encodeCSV},
+	{"ndjson", "application/x-ndjson", []string{"application/ndjson"}, encodeNDJSON},
+	{"xml", "application/xml", []string{"text/xml"}, encodeXML},
+}
+
+// (private) envelopeFormats are the formats of the v2 envelope, which is
+// only available as JSON
+var envelopeFormats = formats[:1]
+
+// (private) supportedFormats returns the names of the given formats
+func supportedFormats(formats []format) string {
+	names := make([]string, len(formats))
+	for i, format := range formats {
+		names[i] = format.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// (private) negotiateFormat chooses the output format for a request among
+// the given ones, the first being the default. The `format` parameter takes
+// precedence over the Accept header. Returns false if none of the requested
+// formats is supported
+func negotiateFormat(request *http.Request, formats []format) (format, bool) {
+	if name := request.URL.Query().Get("format"); len(name) > 0 {
+		for _, format := range formats {
+			if format.name == name {
+				return format, true
+			}
+		}
+		return format{}, false
+	}
+	accept := request.Header.Get("Accept")
+	if len(strings.TrimSpace(accept)) == 0 {
+		return formats[0], true
+	}
+
+	// media ranges by decreasing quality, keeping the order of the header
+	// for equal qualities
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, found := params["q"]; found {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, mediaRange{mediaType, quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	for _, accepted := range ranges {
+		for _, format := range formats {
+			if format.matches(accepted.mediaType) {
+				return format, true
+			}
+		}
+	}
+	return format{}, false
+}
+
+// (private) matches returns if the format satisfies a media range, which
+// can contain wildcards, like `text/*` or `*/*`
+func (format format) matches(mediaRange string) bool {
+	for _, mediaType := range append([]string{format.contentType}, format.aliases...) {
+		if mediaRange == mediaType || mediaRange == "*/*" ||
+			(strings.HasSuffix(mediaRange, "/*") &&
+				strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeJSON(writer io.Writer, users []model.User, profile bool) error {
+	return json.NewEncoder(writer).Encode(users)
+}
+
+// (private) encodeNDJSON writes one JSON object per line and user
+func encodeNDJSON(writer io.Writer, users []model.User, profile bool) error {
+	encoder := json.NewEncoder(writer)
+	for _, user := range users {
+		if err := encoder.Encode(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// (private) encodeXML writes a <users> document with a <user> element per
+// user
+func encodeXML(writer io.Writer, users []model.User, profile bool) error {
+	document := struct {
+		XMLName xml.Name     `xml:"users"`
+		Users   []model.User `xml:"user"`
+	}{Users: users}
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(writer).Encode(document)
+}
+
+// (private) encodeCSV writes a header row followed by a row per user. The
+// profile columns are only present when profiles were requested
+func encodeCSV(writer io.Writer, users []model.User, profile bool) error {
+	out := csv.NewWriter(writer)
+	header := []string{"id", "name", "avatar_url", "html_url", "type", "score"}
+	if profile {
+		header = append(header, "profile_name", "company", "blog",
+			"followers", "public_repos", "created_at")
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, user := range users {
+		row := []string{
+			strconv.FormatInt(user.ID, 10),
+			user.Username,
+			user.AvatarURL,
+			user.HTMLURL,
+			user.Type,
+			strconv.FormatFloat(user.Score, 'f', -1, 64),
+		}
+		if profile {
+			if p := user.Profile; p != nil {
+				row = append(row, p.Name, p.Company, p.Blog,
+					strconv.Itoa(p.Followers), strconv.Itoa(p.PublicRepos),
+					p.CreatedAt.Format(time.RFC3339))
+			} else {
+				row = append(row, "", "", "", "", "", "")
+			}
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		params   string
+		accept   string
+		expected string
+	}{
+		{"", "", "json"},
+		{"", "*/*", "json"},
+		{"", "application/json", "json"},
+		{"", "text/csv", "csv"},
+		{"", "text/*", "csv"},
+		{"", "application/x-ndjson", "ndjson"},
+		{"", "application/xml", "xml"},
+		{"", "text/xml", "xml"},
+		{"", "text/html, application/xml;q=0.9, */*;q=0.8", "xml"},
+		{"", "application/json;q=0.5, text/csv", "csv"},
+		{"", "text/csv;q=0, */*;q=0.1", "json"},
+		{"", "text/html", ""},
+		{"", "image/png, text/plain", ""},
+		{"format=csv", "application/json", "csv"},
+		{"format=ndjson", "", "ndjson"},
+		{"format=yaml", "application/json", ""},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest("GET", "/api/top-contributors?"+tt.params, nil)
+		if len(tt.accept) > 0 {
+			request.Header.Set("Accept", tt.accept)
+		}
+		format, found := negotiateFormat(request, formats)
+		if found != (len(tt.expected) > 0) || format.name != tt.expected {
+			t.Fatalf("%s accept:'%s': expected '%s', got '%s'", tt.params, tt.accept, tt.expected, format.name)
+		}
+	}
+}
+
+func getFormat(t *testing.T, server *ServerContext, params string, accept string) (*http.Response, string) {
+	client := http.Client{Timeout: time.Second}
+	request, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/top-contributors?city=CITY&%s", server.url(), params), nil)
+	if len(accept) > 0 {
+		request.Header.Set("Accept", accept)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, string(body)
+}
+
+func TestServerCSV(t *testing.T) {
+	recorder := newRecorder(3, nil)
+	recorder.Users[1].Profile = &model.Profile{Name: "Some, Name", Followers: 7,
+		CreatedAt: time.Date(2010, 1, 2, 3, 4, 5, 0, time.UTC)}
+	server := createServer(t, recorder)
+	defer server.stop()
+
+	response, body := getFormat(t, server, "fields=profile", "text/csv")
+	if response.StatusCode != 200 || response.Header.Get("Content-Type") != "text/csv" {
+		t.Fatalf("got HTTP code %d, %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || strings.Join(rows[0], ",") !=
+		"id,name,avatar_url,html_url,type,score,profile_name,company,blog,followers,public_repos,created_at" {
+		t.Fatalf("unexpected output: %s", body)
+	}
+	if rows[2][1] != "user_1" || rows[2][6] != "Some, Name" || rows[2][9] != "7" ||
+		rows[2][11] != "2010-01-02T03:04:05Z" || rows[1][6] != "" {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+
+	// basic fields only
+	_, body = getFormat(t, server, "format=csv", "")
+	rows, _ = csv.NewReader(strings.NewReader(body)).ReadAll()
+	if len(rows) != 4 || len(rows[0]) != 6 || rows[3][0] != "2" {
+		t.Fatalf("unexpected output: %s", body)
+	}
+}
+
+func TestServerNDJSON(t *testing.T) {
+	server := createServer(t, newRecorder(3, nil))
+	defer server.stop()
+
+	response, body := getFormat(t, server, "format=ndjson", "")
+	if response.StatusCode != 200 || response.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("got HTTP code %d, %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected output: %s", body)
+	}
+	for i, line := range lines {
+		var user model.User
+		if err := json.Unmarshal([]byte(line), &user); err != nil || user.ID != int64(i) {
+			t.Fatalf("line %d: unexpected %s (%v)", i, line, err)
+		}
+	}
+}
+
+func TestServerXML(t *testing.T) {
+	server := createServer(t, newRecorder(3, nil))
+	defer server.stop()
+
+	response, body := getFormat(t, server, "", "application/xml")
+	if response.StatusCode != 200 || response.Header.Get("Content-Type") != "application/xml" {
+		t.Fatalf("got HTTP code %d, %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	var document struct {
+		Users []model.User `xml:"user"`
+	}
+	if err := xml.Unmarshal([]byte(body), &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Users) != 3 || document.Users[2].Username != "user_2" ||
+		!strings.Contains(body, "<users><user><id>0</id><name>user_0</name></user>") {
+		t.Fatalf("unexpected output: %s", body)
+	}
+}
+
+func TestServerNotAcceptable(t *testing.T) {
+	recorder := newRecorder(3, nil)
+	server := createServer(t, recorder)
+	defer server.stop()
+
+	for _, tt := range []struct{ params, accept string }{
+		{"", "text/html"},
+		{"format=yaml", ""},
+	} {
+		response, body := getFormat(t, server, tt.params, tt.accept)
+		var apiError ApiError
+		json.Unmarshal([]byte(body), &apiError)
+		if response.StatusCode != http.StatusNotAcceptable || apiError.Code != codeNotAcceptable {
+			t.Fatalf("%v: got HTTP code %d, %s", tt, response.StatusCode, body)
+		}
+	}
+	if recorder.Calls != 0 {
+		t.Fatalf("no queries expected, got %d", recorder.Calls)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/adriansr/github-api-service/cache"
+	"github.com/adriansr/github-api-service/githubapi"
+)
+
+const (
+	// path for the liveness endpoint
+	healthPath = "/healthz"
+	// path for the readiness endpoint
+	readyPath = "/readyz"
+	// path for the status endpoint
+	statusPath = "/status"
+)
+
+// Probes are the sources of the state reported by the health endpoints.
+// Unset fields are neither checked nor reported
+type Probes struct {
+	// version of the service
+	Version string
+	// availability of GitHub API, the server is not ready while unhealthy
+	Upstream *githubapi.Health
+	// cache of rankings
+	Cache *cache.Cache
+}
+
+// Probe is the response of the liveness and readiness endpoints
+type Probe struct {
+	Status string `json:"status"`
+	// why the server is not ready
+	Reason string `json:"reason,omitempty"`
+}
+
+// probe statuses
+const (
+	probeOK       = "ok"
+	probeNotReady = "not_ready"
+)
+
+// Status is the response of the status endpoint
+type Status struct {
+	Version       string    `json:"version,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Ready         bool      `json:"ready"`
+	Draining      bool      `json:"draining"`
+	// only if the cache is enabled
+	Cache *CacheStatus `json:"cache,omitempty"`
+	// availability of GitHub API, including the last error
+	Upstream *githubapi.HealthStatus `json:"upstream,omitempty"`
+}
+
+// CacheStatus reports the usage of the cache
+type CacheStatus struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+}
+
+// SetProbes sets the sources of the state reported by the health endpoints,
+// which are always available:
+//
+//	GET /healthz  liveness, succeeds while the server is running
+//	GET /readyz   readiness, fails while draining or GitHub API is unhealthy
+//	GET /status   version, uptime, cache usage and GitHub API availability
+func (server *Server) SetProbes(probes Probes) {
+	server.probes = probes
+}
+
+// Drain makes the readiness endpoint fail, so that no new requests are
+// routed to the server, while requests are still served
+func (server *Server) Drain() {
+	server.draining.Store(true)
+}
+
+// (private) ready returns if the server can receive requests, otherwise
+// the reason
+func (server *Server) ready() (bool, string) {
+	if server.draining.Load() {
+		return false, "server is draining"
+	}
+	if server.probes.Upstream != nil {
+		if status := server.probes.Upstream.Status(); !status.Healthy {
+			return false, status.Reason
+		}
+	}
+	return true, ""
+}
+
+// (private) serveHealth handles liveness probes
+func (server *Server) serveHealth(writer http.ResponseWriter, request *http.Request) {
+	setCommonHeaders(writer)
+	if !allowGet(writer, request) {
+		return
+	}
+	sendObject(writer, http.StatusOK, Probe{Status: probeOK})
+}
+
+// (private) serveReady handles readiness probes
+func (server *Server) serveReady(writer http.ResponseWriter, request *http.Request) {
+	setCommonHeaders(writer)
+	if !allowGet(writer, request) {
+		return
+	}
+	if ready, reason := server.ready(); !ready {
+		sendObject(writer, http.StatusServiceUnavailable, Probe{probeNotReady, reason})
+		return
+	}
+	sendObject(writer, http.StatusOK, Probe{Status: probeOK})
+}
+
+// (private) serveStatus handles requests for the status of the server
+func (server *Server) serveStatus(writer http.ResponseWriter, request *http.Request) {
+	setCommonHeaders(writer)
+	if !allowGet(writer, request) {
+		return
+	}
+	ready, _ := server.ready()
+	status := Status{
+		Version:       server.probes.Version,
+		StartedAt:     server.started,
+		UptimeSeconds: int64(time.Since(server.started).Seconds()),
+		Ready:         ready,
+		Draining:      server.draining.Load(),
+	}
+	if results := server.probes.Cache; results != nil {
+		stats := results.Stats()
+		status.Cache = &CacheStatus{results.Len(), stats.Hits, stats.Misses}
+	}
+	if server.probes.Upstream != nil {
+		upstream := server.probes.Upstream.Status()
+		status.Upstream = &upstream
+	}
+	sendObject(writer, http.StatusOK, status)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/cache"
+	"github.com/adriansr/github-api-service/githubapi"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	server := createServer(t, newRecorder(10, nil))
+	defer server.stop()
+
+	var probe Probe
+	if code := getJSON(t, server, healthPath, &probe); code != http.StatusOK || probe.Status != probeOK {
+		t.Fatalf("got HTTP code %d, %+v", code, probe)
+	}
+	if code := getJSON(t, server, readyPath, &probe); code != http.StatusOK || probe.Status != probeOK {
+		t.Fatalf("got HTTP code %d, %+v", code, probe)
+	}
+	var status Status
+	if code := getJSON(t, server, statusPath, &status); code != http.StatusOK ||
+		!status.Ready || status.Cache != nil || status.Upstream != nil || status.StartedAt.IsZero() {
+		t.Fatalf("got HTTP code %d, %+v", code, status)
+	}
+}
+
+func TestNotReadyWhenUpstreamUnhealthy(t *testing.T) {
+	recorder := newRecorder(10, nil)
+	results := cache.New(recorder, time.Minute, 10)
+	server := createServer(t, results)
+	defer server.stop()
+	health := githubapi.NewHealth(100 * time.Millisecond)
+	server.server.SetProbes(Probes{"1.2.3", health, results})
+
+	var status Status
+	getJSON(t, server, "/api/top-contributors?city=Barcelona", &[]interface{}{})
+	getJSON(t, server, "/api/top-contributors?city=Barcelona", &[]interface{}{})
+	if code := getJSON(t, server, statusPath, &status); code != http.StatusOK || !status.Ready ||
+		status.Version != "1.2.3" || *status.Cache != (CacheStatus{1, 1, 1}) || !status.Upstream.Healthy {
+		t.Fatalf("got HTTP code %d, %+v", code, status)
+	}
+
+	// requests keep failing for the threshold
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			time.Sleep(60 * time.Millisecond)
+		}
+		health.ObserveRequest(githubapi.ResourceSearch, 502, time.Millisecond, nil)
+	}
+	var probe Probe
+	if code := getJSON(t, server, readyPath, &probe); code != http.StatusServiceUnavailable ||
+		probe.Status != probeNotReady || len(probe.Reason) == 0 {
+		t.Fatalf("got HTTP code %d, %+v", code, probe)
+	}
+	// still alive
+	if code := getJSON(t, server, healthPath, &probe); code != http.StatusOK {
+		t.Fatalf("got HTTP code %d, %+v", code, probe)
+	}
+	if getJSON(t, server, statusPath, &status); status.Ready || status.Upstream.LastError != "search request failed with code 502" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// recovers after a successful request
+	health.ObserveRequest(githubapi.ResourceSearch, 200, time.Millisecond, nil)
+	if code := getJSON(t, server, readyPath, &probe); code != http.StatusOK {
+		t.Fatalf("got HTTP code %d, %+v", code, probe)
+	}
+}
+
+func TestNotReadyWhenDraining(t *testing.T) {
+	server := createServer(t, newRecorder(10, nil))
+	defer server.stop()
+	server.server.Drain()
+
+	var probe Probe
+	if code := getJSON(t, server, readyPath, &probe); code != http.StatusServiceUnavailable || probe.Reason != "server is draining" {
+		t.Fatalf("got HTTP code %d, %+v", code, probe)
+	}
+	// requests are still served
+	var users []interface{}
+	if code := getJSON(t, server, "/api/top-contributors?city=Barcelona", &users); code != http.StatusOK || len(users) != 10 {
+		t.Fatalf("got HTTP code %d, %d users", code, len(users))
+	}
+	var status Status
+	if getJSON(t, server, statusPath, &status); status.Ready || !status.Draining {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/adriansr/github-api-service/metrics"
+)
+
+// path for the metrics endpoint
+const metricsPath = "/metrics"
+
+// (private) httpMetrics are the metrics of the requests served
+type httpMetrics struct {
+	requests *metrics.Counter
+	duration *metrics.Histogram
+	inFlight *metrics.Gauge
+}
+
+// EnableMetrics registers the metrics of the requests served in the given
+// registry, and the endpoint that exposes all its metrics:
+//
+//	GET /metrics  metrics in the Prometheus text format
+//
+// Must be called before the server is started
+func (server *Server) EnableMetrics(registry *metrics.Registry) {
+	server.metrics = &httpMetrics{
+		requests: registry.NewCounter("ghas_http_requests_total",
+			"HTTP requests served, by endpoint and status code.", "endpoint", "code"),
+		duration: registry.NewHistogram("ghas_http_request_duration_seconds",
+			"Time to serve HTTP requests, by endpoint and status code.",
+			metrics.DefaultBuckets, "endpoint", "code"),
+		inFlight: registry.NewGauge("ghas_http_requests_in_flight",
+			"HTTP requests being served."),
+	}
+	server.handler.Handle(metricsPath, registry)
+	slog.Info("Registered metrics endpoint", "path", metricsPath)
+}
+
+// (private) observe records a request served by the given endpoint
+func (metrics *httpMetrics) observe(endpoint string, code int, elapsed time.Duration) {
+	label := strconv.Itoa(code)
+	metrics.requests.Inc(endpoint, label)
+	metrics.duration.Observe(elapsed.Seconds(), endpoint, label)
+}
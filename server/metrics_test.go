@@ -0,0 +1,77 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/metrics"
+)
+
+func scrape(t *testing.T, server *ServerContext) string {
+	client := http.Client{Timeout: time.Second}
+	response, err := client.Get(server.url() + metricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != metrics.ContentType {
+		t.Fatalf("unexpected response: %d %v", response.StatusCode, response.Header)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	created, err := New(":0", newRecorder(10, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.EnableMetrics(metrics.NewRegistry())
+	server := startServer(t, created)
+	defer server.stop()
+
+	client := http.Client{Timeout: time.Second}
+	for _, path := range []string{
+		"/api/top-contributors?city=Barcelona",
+		"/api/top-contributors?city=Barcelona",
+		"/api/top-contributors",
+		"/api/v2/top-contributors?city=Barcelona",
+		"/unknown/path",
+	} {
+		response, err := client.Get(server.url() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}
+
+	body := scrape(t, server)
+	for _, series := range []string{
+		"# TYPE ghas_http_requests_total counter",
+		`ghas_http_requests_total{endpoint="/api/top-contributors",code="200"} 2`,
+		`ghas_http_requests_total{endpoint="/api/top-contributors",code="400"} 1`,
+		`ghas_http_requests_total{endpoint="/api/v2/top-contributors",code="200"} 1`,
+		// including the request to check that the server is alive
+		`ghas_http_requests_total{endpoint="/",code="404"} 2`,
+		"# TYPE ghas_http_request_duration_seconds histogram",
+		`ghas_http_request_duration_seconds_bucket{endpoint="/api/top-contributors",code="200",le="+Inf"} 2`,
+		`ghas_http_request_duration_seconds_count{endpoint="/",code="404"} 2`,
+		// the scrape itself is in flight
+		"ghas_http_requests_in_flight 1",
+	} {
+		if !strings.Contains(body, series+"\n") {
+			t.Fatalf("missing series %s in:\n%s", series, body)
+		}
+	}
+
+	// the previous scrape is accounted
+	if body := scrape(t, server); !strings.Contains(body, `ghas_http_requests_total{endpoint="/metrics",code="200"} 1`) {
+		t.Fatalf("scrape not accounted:\n%s", body)
+	}
+}
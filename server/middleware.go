@@ -0,0 +1,95 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/adriansr/github-api-service/logging"
+)
+
+// (private) responseRecorder captures the status code of a response and
+// the message of error responses
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	// set by sendError
+	err string
+}
+
+func (recorder *responseRecorder) WriteHeader(code int) {
+	if recorder.code == 0 {
+		recorder.code = code
+	}
+	recorder.ResponseWriter.WriteHeader(code)
+}
+
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	if recorder.code == 0 {
+		recorder.code = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(data)
+}
+
+// (private) status returns the status code sent
+func (recorder *responseRecorder) status() int {
+	if recorder.code == 0 {
+		return http.StatusOK
+	}
+	return recorder.code
+}
+
+// (private) requestID returns the request ID sent by the client, if valid,
+// otherwise a new one
+func requestID(request *http.Request) string {
+	if id := request.Header.Get(logging.RequestIDHeader); logging.ValidRequestID(id) {
+		return id
+	}
+	return logging.NewRequestID()
+}
+
+// (private) wrap returns a handler that serves the requests with `next`.
+// Every request gets a request ID, which is returned in the response and
+// carried by the request context so that it appears in all the log lines
+// and requests to GitHub API caused by the request. Requests are logged
+// once served and, if enabled, accounted in the metrics. Metrics are
+// labelled with the pattern of the endpoint that serves the request, not
+// the path, to keep the number of series bounded
+func (server *Server) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id := requestID(request)
+		writer.Header().Set(logging.RequestIDHeader, id)
+		ctx := logging.WithRequestID(request.Context(), id)
+		request = request.WithContext(ctx)
+
+		_, endpoint := server.handler.Handler(request)
+		recorder := &responseRecorder{ResponseWriter: writer}
+		if server.metrics != nil {
+			server.metrics.inFlight.Add(1)
+		}
+		started := time.Now()
+		defer func() {
+			elapsed := time.Since(started)
+			code := recorder.status()
+			if server.metrics != nil {
+				server.metrics.inFlight.Add(-1)
+				server.metrics.observe(endpoint, code, elapsed)
+			}
+			attrs := []slog.Attr{
+				slog.String("method", request.Method),
+				slog.String("path", request.URL.Path),
+				slog.Int("status", code),
+				slog.Int64("duration_ms", elapsed.Milliseconds()),
+			}
+			level := slog.LevelInfo
+			if len(recorder.err) > 0 {
+				attrs = append(attrs, slog.String("error", recorder.err))
+				if code >= http.StatusInternalServerError {
+					level = slog.LevelError
+				}
+			}
+			slog.LogAttrs(ctx, level, "Request served", attrs...)
+		}()
+		next.ServeHTTP(recorder, request)
+	})
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/logging"
+)
+
+// LogBuffer collects the log records, safe for concurrent use
+type LogBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *LogBuffer) Write(data []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Write(data)
+}
+
+// Records returns the records with the given message
+func (buffer *LogBuffer) Records(t *testing.T, msg string) []map[string]interface{} {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buffer.buffer.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid record %s: %v", line, err)
+		}
+		if record["msg"] == msg {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// captureLogs replaces the default logger until the returned function is
+// called
+func captureLogs(t *testing.T) (*LogBuffer, func()) {
+	buffer := &LogBuffer{}
+	logger, err := logging.New(buffer, "debug", logging.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	return buffer, func() { slog.SetDefault(previous) }
+}
+
+func TestRequestID(t *testing.T) {
+	logs, restore := captureLogs(t)
+	defer restore()
+	recorder := newRecorder(10, nil)
+	server := createServer(t, recorder)
+	defer server.stop()
+
+	client := http.Client{Timeout: time.Second}
+	for _, tt := range []struct {
+		sent       string
+		propagated bool
+	}{
+		{"client-id-1", true},
+		{"", false},
+		{"not valid", false},
+	} {
+		request, _ := http.NewRequest("GET", server.url()+"/api/top-contributors?city=Barcelona", nil)
+		if len(tt.sent) > 0 {
+			request.Header.Set(logging.RequestIDHeader, tt.sent)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		id := response.Header.Get(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) || (id == tt.sent) != tt.propagated {
+			t.Fatalf("sent '%s', got request ID '%s'", tt.sent, id)
+		}
+		// the ID is available to the getter, to be sent to GitHub API
+		if queried := logging.RequestID(recorder.Context); queried != id {
+			t.Fatalf("expected request ID '%s' in the query context, got '%s'", id, queried)
+		}
+	}
+
+	// every request is logged with its request ID
+	records := logs.Records(t, "Request served")
+	var served []map[string]interface{}
+	for _, record := range records {
+		if record["path"] == "/api/top-contributors" {
+			served = append(served, record)
+		}
+	}
+	if len(served) != 3 || served[0]["request_id"] != "client-id-1" || served[0]["status"] != 200.0 {
+		t.Fatalf("unexpected records: %v", served)
+	}
+	// as well as the log lines produced while serving them
+	processed := logs.Records(t, "Processed request")
+	if len(processed) != 3 || processed[0]["request_id"] != "client-id-1" || processed[0]["results"] != 10.0 {
+		t.Fatalf("unexpected records: %v", processed)
+	}
+}
+
+func TestErrorsLogged(t *testing.T) {
+	logs, restore := captureLogs(t)
+	defer restore()
+	server := createServer(t, newRecorder(10, nil))
+	defer server.stop()
+
+	client := http.Client{Timeout: time.Second}
+	response, err := client.Get(server.url() + "/api/top-contributors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	for _, record := range logs.Records(t, "Request served") {
+		if record["path"] == "/api/top-contributors" {
+			if record["status"] != 400.0 || record["error"] != "missing parameter: city" ||
+				record["request_id"] != response.Header.Get(logging.RequestIDHeader) {
+				t.Fatalf("unexpected record: %v", record)
+			}
+			return
+		}
+	}
+	t.Fatal("request not logged")
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+// (private) paramError describes an invalid request parameter
+type paramError struct {
+	code    string
+	message string
+}
+
+// values for the `fields` parameter
+const (
+	// only the fields available in the search results
+	fieldsBasic = "basic"
+	// also fetch the profile of every user
+	fieldsProfile = "profile"
+)
+
+// (private) parseQuery validates the request parameters and converts them
+// to a Query
+func parseQuery(params url.Values) (model.Query, *paramError) {
+	var query model.Query
+
+	// check count
+	count, err := strconv.Atoi(params.Get("count"))
+	if err != nil {
+		count = defaultCount
+	}
+	if count < 1 || count > model.MaxContributors {
+		return query, &paramError{codeInvalidParameter, "count parameter not valid"}
+	}
+	query.Count = count
+
+	// check city
+	query.Location = params.Get("city")
+	if len(query.Location) == 0 {
+		return query, &paramError{codeMissingParameter, "missing parameter: city"}
+	}
+
+	// check ranking
+	query.Sort, query.Order = params.Get("sort"), params.Get("order")
+	if len(query.Sort) > 0 && !model.ValidSort(query.Sort) {
+		return query, &paramError{codeInvalidParameter, "sort parameter not valid"}
+	}
+	if len(query.Order) > 0 && !model.ValidOrder(query.Order) {
+		return query, &paramError{codeInvalidParameter, "order parameter not valid"}
+	}
+	query.Sort, query.Order = query.SortOrder()
+
+	// check qualifiers
+	if paramErr := parseQualifiers(params, &query.Qualifiers); paramErr != nil {
+		return query, paramErr
+	}
+
+	// check fields
+	switch params.Get("fields") {
+	case "", fieldsBasic:
+	case fieldsProfile:
+		query.Profile = true
+	default:
+		return query, &paramError{codeInvalidParameter, "fields parameter not valid"}
+	}
+	return query, nil
+}
+
+// (private) parseQualifiers parses the optional parameters that restrict
+// the users considered for a ranking
+func parseQualifiers(params url.Values, qualifiers *model.Qualifiers) *paramError {
+	var paramErr *paramError
+	qualifiers.Language = params.Get("language")
+	qualifiers.Type = params.Get("type")
+	for _, param := range []struct {
+		name   string
+		target **int
+	}{
+		{"followers_min", &qualifiers.Followers.Min},
+		{"followers_max", &qualifiers.Followers.Max},
+		{"repos_min", &qualifiers.Repos.Min},
+		{"repos_max", &qualifiers.Repos.Max},
+	} {
+		if *param.target, paramErr = parseInt(params, param.name); paramErr != nil {
+			return paramErr
+		}
+	}
+	if qualifiers.Created.From, paramErr = parseDate(params, "created_after"); paramErr != nil {
+		return paramErr
+	}
+	if qualifiers.Created.To, paramErr = parseDate(params, "created_before"); paramErr != nil {
+		return paramErr
+	}
+	if err := qualifiers.Validate(); err != nil {
+		return &paramError{codeInvalidParameter, "invalid qualifiers: " + err.Error()}
+	}
+	return nil
+}
+
+// (private) parseInt parses an optional integer parameter, returning nil
+// if not present
+func parseInt(params url.Values, name string) (*int, *paramError) {
+	value := params.Get(name)
+	if len(value) == 0 {
+		return nil, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, &paramError{codeInvalidParameter, name + " parameter not valid"}
+	}
+	return &parsed, nil
+}
+
+// (private) parseDate parses an optional date parameter in YYYY-MM-DD
+// format, returning a zero time if not present
+func parseDate(params url.Values, name string) (time.Time, *paramError) {
+	value := params.Get(name)
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(model.DateFormat, value)
+	if err != nil {
+		return time.Time{}, &paramError{codeInvalidParameter, name + " parameter not valid"}
+	}
+	return parsed, nil
+}
@@ -1,13 +1,21 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net"
 	"net/http"
 	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/adriansr/github-api-service/deepscan"
 	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/scheduler"
+	"github.com/adriansr/github-api-service/snapshot"
 	"github.com/adriansr/github-api-service/util"
 )
 
@@ -19,16 +27,33 @@ type Server struct {
 	// interface to fetch the top contributors
 	client model.TopContributorGetter
 
+	// runs deep scans, if enabled
+	deepScans *deepscan.Manager
+
+	// history of rankings, if enabled
+	snapshots *snapshot.Store
+
+	// refreshes watched locations, if enabled
+	watches *scheduler.Scheduler
+
+	// metrics of the requests served, if enabled
+	metrics *httpMetrics
+
+	// sources of the health endpoints
+	probes Probes
+	// when the server was created
+	started time.Time
+	// set when the server must no longer receive new requests
+	draining atomic.Bool
+
 	// multiplexor for requests
 	handler *http.ServeMux
 
 	// internal server handle
 	underlying *http.Server
-}
 
-// ApiError struct is used to represent the error responses from the API
-type ApiError struct {
-	Error string `json:"error"`
+	// cancels the context of all in-flight requests
+	cancel context.CancelFunc
 }
 
 const (
@@ -38,6 +63,8 @@ const (
 	serverName = "adriansr/github-api-service"
 	// by default 50 results are fetched if count is not specified
 	defaultCount = 50
+	// `Warning` header for results served from the cache after expiring
+	staleWarning = `110 - "Response is Stale"`
 )
 
 func setCommonHeaders(writer http.ResponseWriter) {
@@ -45,71 +72,98 @@ func setCommonHeaders(writer http.ResponseWriter) {
 	writer.Header().Add("Server", serverName)
 }
 
-func sendError(writer http.ResponseWriter, code int, msg string) {
-	object := ApiError{msg}
+// (private) sendObject sends an object as a JSON response
+func sendObject(writer http.ResponseWriter, status int, object interface{}) {
 	body, err := json.Marshal(object)
 	if err != nil {
-		code = http.StatusInternalServerError
-		body = []byte(`{"error": "internal error"}`)
+		sendError(writer, http.StatusInternalServerError, codeInternalError,
+			"output representation failed: "+err.Error())
+		return
 	}
-	writer.WriteHeader(code)
+	writer.WriteHeader(status)
 	writer.Write(body)
-	log.Printf("Error response %d '%s'", code, msg)
 }
 
 // ServeHTTP handles HTTP requests to the API endpoint
 func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	setCommonHeaders(writer)
-
-	// only accept GET requests
-	if request.Method != "GET" {
-		// required when sending 405 Method Not Allowed
-		writer.Header().Add("Allow", "GET")
-		sendError(writer, http.StatusMethodNotAllowed, "only GET requests allowed")
+	if !allowGet(writer, request) {
+		return
+	}
+	// choose the output format before querying, so that unsupported formats
+	// don't consume quota
+	format, found := negotiateFormat(request, formats)
+	if !found {
+		sendError(writer, http.StatusNotAcceptable, codeNotAcceptable,
+			"output format not supported, use one of: "+supportedFormats(formats))
+		return
+	}
+	query, paramErr := parseQuery(request.URL.Query())
+	result, ok := server.rank(writer, request, query, paramErr)
+	if !ok {
 		return
 	}
 
-	// check count
-	params := request.URL.Query()
-	count, err := strconv.Atoi(params.Get("count"))
-	if err != nil {
-		count = defaultCount
+	// report the completeness of the ranking, as it's not part of the output
+	writer.Header().Set("X-Total-Count", strconv.Itoa(result.TotalCount))
+	if result.Incomplete {
+		writer.Header().Set("X-Incomplete-Results", "true")
 	}
 
-	if count != 50 && count != 100 && count != 150 {
-		sendError(writer, http.StatusBadRequest, "count parameter not valid")
+	// convert to the requested format
+	var body bytes.Buffer
+	if err := format.encode(&body, result.Users, query.Profile); err != nil {
+		sendError(writer, http.StatusInternalServerError, codeInternalError,
+			"output representation failed: "+err.Error())
 		return
 	}
+	writer.Header().Set("Content-Type", format.contentType)
+	writer.Header().Add("Vary", "Accept")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(body.Bytes())
+	slog.DebugContext(request.Context(), "Processed request",
+		"results", len(result.Users), "format", format.name, "cached", result.Cached)
+}
 
-	// check city
-	city := params.Get("city")
-	if len(city) == 0 {
-		sendError(writer, http.StatusBadRequest, "missing parameter: city")
-		return
+// (private) allowGet checks that the request uses the GET method, otherwise
+// it sends an error response and returns false
+func allowGet(writer http.ResponseWriter, request *http.Request) bool {
+	if request.Method != "GET" {
+		// required when sending 405 Method Not Allowed
+		writer.Header().Add("Allow", "GET")
+		sendError(writer, http.StatusMethodNotAllowed, codeMethodNotAllowed,
+			"only GET requests allowed")
+		return false
+	}
+	return true
+}
+
+// (private) rank obtains the ranking for the query parsed from the request.
+// On failure, it sends the error response and returns false
+func (server *Server) rank(writer http.ResponseWriter, request *http.Request, query model.Query, paramErr *paramError) (*model.Ranking, bool) {
+	if paramErr != nil {
+		sendError(writer, http.StatusBadRequest, paramErr.code, paramErr.message)
+		return nil, false
 	}
 
 	// forward request to the TopContributorGetter instace
-	result, err := server.client.GetTopContributors(city, count)
+	result, err := server.client.GetTopContributors(request.Context(), query)
 	if err != nil {
-		sendError(writer, http.StatusInternalServerError,
-			"query failed: "+err.Error())
-		return
+		sendQueryError(writer, err)
+		return nil, false
 	}
 
-	// convert to JSON
-	body, err := json.Marshal(result)
-	if err != nil {
-		sendError(writer, http.StatusInternalServerError,
-			"output representation failed: "+err.Error())
-		return
+	// report the ranking used
+	sort, order := query.SortOrder()
+	writer.Header().Set("X-Ranking-Sort", sort)
+	writer.Header().Set("X-Ranking-Order", order)
+	if result.Stale {
+		writer.Header().Set("Warning", staleWarning)
 	}
-	writer.WriteHeader(http.StatusOK)
-	writer.Write(body)
-	log.Printf("Processed request (%d results)", len(result))
+	return result, true
 }
 
 func notFound(writer http.ResponseWriter, request *http.Request) {
-	log.Printf("Request for unknown path: %s", request.URL)
 	http.NotFound(writer, request)
 }
 
@@ -121,11 +175,22 @@ func New(address string, client model.TopContributorGetter) (*Server, error) {
 	if err != nil {
 		return nil, util.WrapError("Listen failed", err)
 	}
-	server := &Server{listener, client, http.NewServeMux(), nil}
+	server := &Server{
+		Address: listener,
+		client:  client,
+		handler: http.NewServeMux(),
+		started: time.Now(),
+	}
 	server.handler.Handle(apiPath, server)
-	// attach a NotFound handler to / so it can log 404 errors
+	server.handler.HandleFunc(apiV2Path, server.serveV2)
+	server.handler.HandleFunc(healthPath, server.serveHealth)
+	server.handler.HandleFunc(readyPath, server.serveReady)
+	server.handler.HandleFunc(statusPath, server.serveStatus)
+	// attach a NotFound handler to / so that requests for unknown paths are
+	// accounted to the / endpoint
 	server.handler.HandleFunc("/", notFound)
-	log.Printf("Registered API endpoint '%s'", apiPath)
+	slog.Info("Registered API endpoints", "paths", []string{apiPath, apiV2Path})
+	slog.Info("Registered health endpoints", "paths", []string{healthPath, readyPath, statusPath})
 	return server, nil
 }
 
@@ -135,15 +200,50 @@ func (server *Server) Start() error {
 	if server.underlying != nil {
 		return util.NewError("already running")
 	}
-	log.Printf("Accepting requests at %s", server.Address.Addr())
-	server.underlying = &http.Server{Handler: server.handler}
-	return server.underlying.Serve(server.Address)
+	slog.Info("Accepting requests", "address", server.Address.Addr().String())
+	// requests inherit a context that is cancelled when the server is
+	// stopped, so that in-flight queries to GitHub are aborted
+	ctx, cancel := context.WithCancel(context.Background())
+	server.cancel = cancel
+	server.underlying = &http.Server{
+		Handler:     server.wrap(server.handler),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	if err := server.underlying.Serve(server.Address); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
-// Stop shuts the server down
-func (server *Server) Stop() error {
+// Shutdown stops the server gracefully. First it reports that it's not
+// ready and keeps serving requests for `delay`, so that clients and load
+// balancers stop sending new ones. Then it stops accepting connections and
+// waits up to `timeout` for the requests in flight to complete. Requests
+// still in flight after that are aborted and their connections closed
+func (server *Server) Shutdown(delay, timeout time.Duration) error {
 	if server.underlying == nil {
 		return util.NewError("already stopped")
 	}
-	return server.underlying.Shutdown(nil)
+	server.Drain()
+	if delay > 0 {
+		slog.Info("Draining before shutdown", "delay", delay.String())
+		time.Sleep(delay)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.underlying.Shutdown(ctx)
+	// aborts the queries to GitHub, if any
+	server.cancel()
+	if errors.Is(err, context.DeadlineExceeded) {
+		if timeout > 0 {
+			slog.Warn("Shutdown timeout exceeded, aborting requests in flight", "timeout", timeout.String())
+		}
+		err = server.underlying.Close()
+	}
+	return err
+}
+
+// Stop shuts the server down immediately, aborting the requests in flight
+func (server *Server) Stop() error {
+	return server.Shutdown(0, 0)
 }
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"testing"
@@ -13,11 +15,15 @@ import (
 
 // Recorder helper to record calls to the TopContributorGetter interface
 type Recorder struct {
-	Users []model.User
-	Error error
-	City  string
-	Count int
-	Calls int
+	Users      []model.User
+	Incomplete bool
+	Error      error
+	Query      model.Query
+	City       string
+	Count      int
+	Calls      int
+	// context of the last query
+	Context context.Context
 }
 
 func newRecorder(countUsers int, err error) *Recorder {
@@ -28,11 +34,20 @@ func newRecorder(countUsers int, err error) *Recorder {
 	return &Recorder{Users: users, Error: err, Calls: 0}
 }
 
-func (recorder *Recorder) GetTopContributors(location string, count int) ([]model.User, error) {
+func (recorder *Recorder) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
 	recorder.Calls++
-	recorder.City = location
-	recorder.Count = count
-	return recorder.Users, recorder.Error
+	recorder.Context = ctx
+	recorder.Query = query
+	recorder.City = query.Location
+	recorder.Count = query.Count
+	if recorder.Error != nil {
+		return nil, recorder.Error
+	}
+	return &model.Ranking{
+		Users:      recorder.Users,
+		TotalCount: len(recorder.Users),
+		Incomplete: recorder.Incomplete,
+	}, nil
 }
 
 // ServerContext helper to create a testable HTTP server
@@ -47,11 +62,16 @@ func createServer(t *testing.T, handler model.TopContributorGetter) *ServerConte
 	if err != nil {
 		t.Fatalf("failed creating server: %s", err)
 	}
+	return startServer(t, server)
+}
 
+// startServer starts an already created server, for the tests that need
+// to set it up before starting
+func startServer(t *testing.T, server *Server) *ServerContext {
 	ctx := &ServerContext{server, make(chan error, 1), t}
 
 	go func() {
-		err = server.Start()
+		err := server.Start()
 		if err != nil {
 			ctx.terminator <- err
 		}
@@ -134,6 +154,30 @@ func TestServerValidRequest(t *testing.T) {
 	server.stop()
 }
 
+func TestServerIncompleteResults(t *testing.T) {
+	for _, incomplete := range []bool{false, true} {
+		recorder := newRecorder(10, nil)
+		recorder.Incomplete = incomplete
+		server := createServer(t, recorder)
+
+		client := http.Client{Timeout: time.Second}
+		response, err := client.Get(fmt.Sprintf("%s/api/top-contributors?city=CITY", server.url()))
+		server.stop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != 200 {
+			t.Fatalf("got HTTP code %d", response.StatusCode)
+		}
+		if total := response.Header.Get("X-Total-Count"); total != "10" {
+			t.Fatalf("wrong total count: %s", total)
+		}
+		if flag := response.Header.Get("X-Incomplete-Results"); (flag == "true") != incomplete {
+			t.Fatalf("wrong incomplete flag: '%s'", flag)
+		}
+	}
+}
+
 func TestServerDefaultCount(t *testing.T) {
 	recorder := newRecorder(10, nil)
 	server := createServer(t, recorder)
@@ -165,6 +209,29 @@ func TestServerInvalidCount(t *testing.T) {
 	recorder := newRecorder(10, nil)
 	server := createServer(t, recorder)
 
+	client := http.Client{Timeout: time.Second}
+	for _, count := range []string{"0", "-50", "1001"} {
+		url := fmt.Sprintf("%s/api/top-contributors?city=CITY&count=%s", server.url(), count)
+
+		response, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != 400 {
+			t.Fatalf("count %s: got HTTP code %d", count, response.StatusCode)
+		}
+		if recorder.Calls != 0 {
+			t.Fatalf("no query expected, got %d", recorder.Calls)
+		}
+	}
+
+	server.stop()
+}
+
+func TestServerArbitraryCount(t *testing.T) {
+	recorder := newRecorder(10, nil)
+	server := createServer(t, recorder)
+
 	client := http.Client{Timeout: time.Second}
 	url := fmt.Sprintf("%s/api/top-contributors?city=CITY&count=333", server.url())
 
@@ -172,16 +239,132 @@ func TestServerInvalidCount(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if response.StatusCode != 400 {
+	if response.StatusCode != 200 {
 		t.Fatalf("got HTTP code %d", response.StatusCode)
 	}
-	if recorder.Calls != 0 {
-		t.Fatalf("no query expected, got %d", recorder.Calls)
+	if recorder.Count != 333 {
+		t.Fatalf("wrong count, got %d", recorder.Count)
 	}
-
 	server.stop()
 }
 
+func TestServerFields(t *testing.T) {
+	recorder := newRecorder(10, nil)
+	server := createServer(t, recorder)
+	defer server.stop()
+
+	client := http.Client{Timeout: time.Second}
+	tests := []struct {
+		fields  string
+		status  int
+		profile bool
+	}{
+		{"", 200, false},
+		{"basic", 200, false},
+		{"profile", 200, true},
+		{"everything", 400, false},
+	}
+	for _, tt := range tests {
+		recorder.Query = model.Query{}
+		url := fmt.Sprintf("%s/api/top-contributors?city=CITY&fields=%s", server.url(), tt.fields)
+		response, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != tt.status {
+			t.Fatalf("fields=%s: got HTTP code %d", tt.fields, response.StatusCode)
+		}
+		if recorder.Query.Profile != tt.profile {
+			t.Fatalf("fields=%s: wrong query %+v", tt.fields, recorder.Query)
+		}
+	}
+}
+
+func TestServerSort(t *testing.T) {
+	recorder := newRecorder(10, nil)
+	server := createServer(t, recorder)
+	defer server.stop()
+
+	client := http.Client{Timeout: time.Second}
+	tests := []struct {
+		params      string
+		status      int
+		sort, order string
+	}{
+		{"", 200, "repositories", "desc"},
+		{"&sort=followers", 200, "followers", "desc"},
+		{"&sort=joined&order=asc", 200, "joined", "asc"},
+		{"&order=asc", 200, "repositories", "asc"},
+		{"&sort=stars", 400, "", ""},
+		{"&order=random", 400, "", ""},
+	}
+	for _, tt := range tests {
+		recorder.Query = model.Query{}
+		url := fmt.Sprintf("%s/api/top-contributors?city=CITY%s", server.url(), tt.params)
+		response, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != tt.status {
+			t.Fatalf("%s: got HTTP code %d", tt.params, response.StatusCode)
+		}
+		if recorder.Query.Sort != tt.sort || recorder.Query.Order != tt.order {
+			t.Fatalf("%s: wrong query %+v", tt.params, recorder.Query)
+		}
+		if tt.status == 200 && (response.Header.Get("X-Ranking-Sort") != tt.sort ||
+			response.Header.Get("X-Ranking-Order") != tt.order) {
+			t.Fatalf("%s: wrong ranking headers %v", tt.params, response.Header)
+		}
+	}
+}
+
+func TestServerQualifiers(t *testing.T) {
+	recorder := newRecorder(10, nil)
+	server := createServer(t, recorder)
+	defer server.stop()
+
+	client := http.Client{Timeout: time.Second}
+	url := fmt.Sprintf("%s/api/top-contributors?city=Berlin&language=go&followers_min=101"+
+		"&repos_max=50&created_after=2010-01-01&created_before=2015-12-31&type=user", server.url())
+	response, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 {
+		t.Fatalf("got HTTP code %d", response.StatusCode)
+	}
+	q := recorder.Query.Qualifiers
+	if q.Language != "go" || q.Type != model.TypeUser ||
+		q.Followers.Min == nil || *q.Followers.Min != 101 || q.Followers.Max != nil ||
+		q.Repos.Max == nil || *q.Repos.Max != 50 || q.Repos.Min != nil ||
+		q.Created.From.Format(model.DateFormat) != "2010-01-01" ||
+		q.Created.To.Format(model.DateFormat) != "2015-12-31" {
+		t.Fatalf("wrong qualifiers: %+v", q)
+	}
+
+	for _, params := range []string{
+		"followers_min=many",
+		"repos_min=-1",
+		"repos_min=10&repos_max=5",
+		"created_after=yesterday",
+		"created_after=2015-01-01&created_before=2014-01-01",
+		"type=bot",
+	} {
+		recorder.Calls = 0
+		url := fmt.Sprintf("%s/api/top-contributors?city=Berlin&%s", server.url(), params)
+		response, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != 400 {
+			t.Fatalf("%s: got HTTP code %d", params, response.StatusCode)
+		}
+		if recorder.Calls != 0 {
+			t.Fatalf("%s: no query expected", params)
+		}
+	}
+}
+
 func TestServerNoCity(t *testing.T) {
 	recorder := newRecorder(10, nil)
 	server := createServer(t, recorder)
@@ -246,3 +429,223 @@ func TestQueryFailure(t *testing.T) {
 	}
 	server.stop()
 }
+
+func TestQueryQueueFull(t *testing.T) {
+	recorder := newRecorder(0, util.ErrQueueFull)
+	server := createServer(t, recorder)
+
+	client := http.Client{Timeout: time.Second}
+	url := fmt.Sprintf("%s/api/top-contributors?city=Barcelona", server.url())
+
+	response, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got HTTP code %d", response.StatusCode)
+	}
+	if retry := response.Header.Get("Retry-After"); retry != "1" {
+		t.Fatalf("wrong Retry-After header: '%s'", retry)
+	}
+	server.stop()
+}
+
+func TestQueryErrorKinds(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{util.NewError("error"), 500, "internal_error"},
+		{util.NewErrorKind(util.InvalidQuery, "bad"), 400, "invalid_query"},
+		{util.NewRateLimitError("limited", time.Minute), 429, "rate_limited"},
+		{util.ErrQueueFull, 503, "overloaded"},
+		{util.NewErrorKind(util.Unavailable, "down"), 502, "upstream_unavailable"},
+		{util.WrapError("wrapped", context.DeadlineExceeded), 504, "upstream_timeout"},
+		{util.NewErrorKind(util.Decode, "garbage"), 502, "upstream_decode_failure"},
+	}
+	for _, tt := range tests {
+		recorder := newRecorder(0, tt.err)
+		server := createServer(t, recorder)
+
+		client := http.Client{Timeout: time.Second}
+		url := fmt.Sprintf("%s/api/top-contributors?city=Barcelona", server.url())
+
+		response, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var apiError ApiError
+		if err := json.NewDecoder(response.Body).Decode(&apiError); err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != tt.status || apiError.Code != tt.code {
+			t.Errorf("'%s': got %d '%s', expected %d '%s'",
+				tt.err, response.StatusCode, apiError.Code, tt.status, tt.code)
+		}
+		server.stop()
+	}
+}
+
+func TestQueryRateLimited(t *testing.T) {
+	recorder := newRecorder(0, util.NewRateLimitError("limited", 1500*time.Millisecond))
+	server := createServer(t, recorder)
+
+	client := http.Client{Timeout: time.Second}
+	url := fmt.Sprintf("%s/api/top-contributors?city=Barcelona", server.url())
+
+	response, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got HTTP code %d", response.StatusCode)
+	}
+	if retry := response.Header.Get("Retry-After"); retry != "2" {
+		t.Fatalf("wrong Retry-After header: '%s'", retry)
+	}
+	server.stop()
+}
+
+// Blocker helper for a TopContributorGetter that never returns until its
+// context is cancelled
+type Blocker struct {
+	Started   chan struct{}
+	Cancelled chan struct{}
+}
+
+func (blocker *Blocker) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	close(blocker.Started)
+	<-ctx.Done()
+	close(blocker.Cancelled)
+	return nil, ctx.Err()
+}
+
+func TestClientDisconnectCancelsQuery(t *testing.T) {
+	blocker := &Blocker{make(chan struct{}), make(chan struct{})}
+	server := createServer(t, blocker)
+
+	client := http.Client{Timeout: 200 * time.Millisecond}
+	url := fmt.Sprintf("%s/api/top-contributors?city=Barcelona", server.url())
+
+	if _, err := client.Get(url); err == nil {
+		t.Fatal("client timeout expected")
+	}
+	<-blocker.Started
+	select {
+	case <-blocker.Cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("query not cancelled after client disconnected")
+	}
+	server.stop()
+}
+
+func TestStopCancelsQuery(t *testing.T) {
+	blocker := &Blocker{make(chan struct{}), make(chan struct{})}
+	server := createServer(t, blocker)
+
+	client := http.Client{Timeout: 5 * time.Second}
+	url := fmt.Sprintf("%s/api/top-contributors?city=Barcelona", server.url())
+
+	go client.Get(url)
+	<-blocker.Started
+	server.stop()
+	select {
+	case <-blocker.Cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("query not cancelled after server stopped")
+	}
+}
+
+// Gate helper for a TopContributorGetter that returns an empty ranking once
+// released
+type Gate struct {
+	Started chan struct{}
+	Release chan struct{}
+}
+
+func (gate *Gate) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	close(gate.Started)
+	<-gate.Release
+	return &model.Ranking{}, nil
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	gate := &Gate{make(chan struct{}), make(chan struct{})}
+	server := createServer(t, gate)
+
+	client := http.Client{Timeout: 5 * time.Second}
+	url := fmt.Sprintf("%s/api/top-contributors?city=Barcelona", server.url())
+
+	statuses := make(chan int, 1)
+	go func() {
+		response, err := client.Get(url)
+		if err != nil {
+			statuses <- 0
+			return
+		}
+		response.Body.Close()
+		statuses <- response.StatusCode
+	}()
+	<-gate.Started
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.server.Shutdown(200*time.Millisecond, 5*time.Second)
+	}()
+
+	// readiness fails, but requests are served during the delay
+	var probe Probe
+	for attempt := 0; probe.Status != probeNotReady; attempt++ {
+		if attempt == 10 {
+			t.Fatal("server still ready while draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+		if code := getJSON(t, server, readyPath, &probe); code != http.StatusOK && code != http.StatusServiceUnavailable {
+			t.Fatalf("unexpected status code %d", code)
+		}
+	}
+
+	// the request in flight is awaited past the delay
+	select {
+	case err := <-stopped:
+		t.Fatalf("shutdown didn't wait for the request in flight: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+	close(gate.Release)
+	if status := <-statuses; status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdown didn't finish after the request completed")
+	}
+}
+
+func TestShutdownTimeoutAbortsRequests(t *testing.T) {
+	blocker := &Blocker{make(chan struct{}), make(chan struct{})}
+	server := createServer(t, blocker)
+
+	client := http.Client{Timeout: 5 * time.Second}
+	url := fmt.Sprintf("%s/api/top-contributors?city=Barcelona", server.url())
+
+	go client.Get(url)
+	<-blocker.Started
+	start := time.Now()
+	if err := server.server.Shutdown(0, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("shutdown took %s", elapsed)
+	}
+	select {
+	case <-blocker.Cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("query not cancelled after shutdown timeout")
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/adriansr/github-api-service/snapshot"
+)
+
+const (
+	// path for the snapshot endpoints
+	snapshotPath = "/api/snapshots"
+	// error code for unknown snapshots
+	codeSnapshotNotFound = "snapshot_not_found"
+)
+
+// EnableSnapshots registers the endpoints to browse the snapshots in the
+// given store and compare them:
+//
+//	GET /api/snapshots?city=...           lists the snapshots of a city
+//	GET /api/snapshots/{id}               returns a snapshot
+//	GET /api/snapshots/diff?from=..&to=.. compares two snapshots
+func (server *Server) EnableSnapshots(store *snapshot.Store) {
+	server.snapshots = store
+	server.handler.HandleFunc(snapshotPath, server.listSnapshots)
+	server.handler.HandleFunc(snapshotPath+"/", server.getSnapshot)
+	slog.Info("Registered API endpoint", "path", snapshotPath)
+}
+
+// (private) listSnapshots handles requests for the snapshots of a city
+func (server *Server) listSnapshots(writer http.ResponseWriter, request *http.Request) {
+	setCommonHeaders(writer)
+	if !allowGet(writer, request) {
+		return
+	}
+	city := request.URL.Query().Get("city")
+	if len(city) == 0 {
+		sendError(writer, http.StatusBadRequest, codeMissingParameter, "missing parameter: city")
+		return
+	}
+	sendObject(writer, http.StatusOK, server.snapshots.List(city))
+}
+
+// (private) getSnapshot handles requests for a snapshot or a diff
+func (server *Server) getSnapshot(writer http.ResponseWriter, request *http.Request) {
+	setCommonHeaders(writer)
+	if !allowGet(writer, request) {
+		return
+	}
+	id := strings.TrimPrefix(request.URL.Path, snapshotPath+"/")
+	if id == "diff" {
+		server.diffSnapshots(writer, request)
+		return
+	}
+	snapshot, ok := server.loadSnapshot(writer, id)
+	if ok {
+		sendObject(writer, http.StatusOK, snapshot)
+	}
+}
+
+// (private) diffSnapshots handles requests to compare two snapshots
+func (server *Server) diffSnapshots(writer http.ResponseWriter, request *http.Request) {
+	params := request.URL.Query()
+	var snapshots [2]*snapshot.Snapshot
+	for i, name := range []string{"from", "to"} {
+		id := params.Get(name)
+		if len(id) == 0 {
+			sendError(writer, http.StatusBadRequest, codeMissingParameter, "missing parameter: "+name)
+			return
+		}
+		var ok bool
+		if snapshots[i], ok = server.loadSnapshot(writer, id); !ok {
+			return
+		}
+	}
+	diff, err := snapshot.Compare(snapshots[0], snapshots[1])
+	if err != nil {
+		sendError(writer, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	sendObject(writer, http.StatusOK, diff)
+}
+
+// (private) loadSnapshot returns the snapshot with the given ID. On failure,
+// it sends the error response and returns false
+func (server *Server) loadSnapshot(writer http.ResponseWriter, id string) (*snapshot.Snapshot, bool) {
+	result, err := server.snapshots.Get(id)
+	if errors.Is(err, snapshot.ErrNotFound) {
+		sendError(writer, http.StatusNotFound, codeSnapshotNotFound, "unknown snapshot: "+id)
+		return nil, false
+	}
+	if err != nil {
+		sendQueryError(writer, err)
+		return nil, false
+	}
+	return result, true
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/snapshot"
+)
+
+func getJSON(t *testing.T, server *ServerContext, path string, result interface{}) int {
+	client := http.Client{Timeout: time.Second}
+	response, err := client.Get(server.url() + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode
+}
+
+func TestSnapshotEndpoints(t *testing.T) {
+	store, err := snapshot.NewStore("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := model.Query{Location: "Barcelona", Count: 10}
+	first, _ := store.Save(query, &model.Ranking{Users: []model.User{{ID: 1, Username: "a"}, {ID: 2, Username: "b"}}})
+	second, _ := store.Save(query, &model.Ranking{Users: []model.User{{ID: 2, Username: "b"}, {ID: 3, Username: "c"}}})
+	other, _ := store.Save(model.Query{Location: "Madrid", Count: 10}, &model.Ranking{})
+
+	server := createServer(t, newRecorder(0, nil))
+	defer server.stop()
+	server.server.EnableSnapshots(store)
+
+	var infos []snapshot.Info
+	if status := getJSON(t, server, "/api/snapshots?city=barcelona", &infos); status != 200 || len(infos) != 2 {
+		t.Fatalf("got HTTP code %d, %+v", status, infos)
+	}
+	var result snapshot.Snapshot
+	if status := getJSON(t, server, "/api/snapshots/"+first.ID, &result); status != 200 || len(result.Users) != 2 {
+		t.Fatalf("got HTTP code %d, %+v", status, result)
+	}
+	var diff snapshot.Diff
+	path := fmt.Sprintf("/api/snapshots/diff?from=%s&to=%s", first.ID, second.ID)
+	if status := getJSON(t, server, path, &diff); status != 200 ||
+		len(diff.Entrants) != 1 || len(diff.Dropouts) != 1 || len(diff.Moved) != 1 {
+		t.Fatalf("got HTTP code %d, %+v", status, diff)
+	}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/api/snapshots", http.StatusBadRequest, codeMissingParameter},
+		{"/api/snapshots/unknown", http.StatusNotFound, codeSnapshotNotFound},
+		{"/api/snapshots/diff?from=" + first.ID, http.StatusBadRequest, codeMissingParameter},
+		{"/api/snapshots/diff?from=" + first.ID + "&to=unknown", http.StatusNotFound, codeSnapshotNotFound},
+		{"/api/snapshots/diff?from=" + first.ID + "&to=" + other.ID, http.StatusBadRequest, codeInvalidParameter},
+	} {
+		var apiError ApiError
+		if status := getJSON(t, server, tt.path, &apiError); status != tt.status || apiError.Code != tt.code {
+			t.Fatalf("%s: got HTTP code %d, %+v", tt.path, status, apiError)
+		}
+	}
+}
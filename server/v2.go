@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// path for the version 2 of the API endpoint, which wraps the results in
+// an Envelope
+const apiV2Path = "/api/v2/top-contributors"
+
+// values for Envelope.Cache
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// Envelope is the response of the version 2 of the API. Besides a page of
+// the ranking, it includes metadata about the query and its results
+type Envelope struct {
+	Items []model.User `json:"items"`
+	Query QueryEcho    `json:"query"`
+	// number of users matching the query according to GitHub
+	TotalCount int `json:"total_count"`
+	// set when GitHub couldn't provide all the results
+	Incomplete bool `json:"incomplete"`
+	// when the ranking was obtained from GitHub
+	GeneratedAt time.Time `json:"generated_at"`
+	// whether the ranking was served from the cache, `hit` or `miss`
+	Cache string `json:"cache"`
+	// set when the ranking was served from the cache after expiring,
+	// because it's being refreshed or GitHub API failed
+	Stale bool `json:"stale"`
+	// opaque value to pass in the `cursor` parameter to get the next page,
+	// empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// QueryEcho describes the query that produced an Envelope, with defaults
+// applied
+type QueryEcho struct {
+	City          string `json:"city"`
+	Count         int    `json:"count"`
+	Offset        int    `json:"offset"`
+	Sort          string `json:"sort"`
+	Order         string `json:"order"`
+	Fields        string `json:"fields"`
+	Language      string `json:"language,omitempty"`
+	FollowersMin  *int   `json:"followers_min,omitempty"`
+	FollowersMax  *int   `json:"followers_max,omitempty"`
+	ReposMin      *int   `json:"repos_min,omitempty"`
+	ReposMax      *int   `json:"repos_max,omitempty"`
+	CreatedAfter  string `json:"created_after,omitempty"`
+	CreatedBefore string `json:"created_before,omitempty"`
+	Type          string `json:"type,omitempty"`
+}
+
+// (private) serveV2 handles HTTP requests to the version 2 of the API
+// endpoint. It accepts the same parameters as the original one, where count
+// is the page size, plus a `cursor` to request the following pages
+func (server *Server) serveV2(writer http.ResponseWriter, request *http.Request) {
+	setCommonHeaders(writer)
+	if !allowGet(writer, request) {
+		return
+	}
+	if _, found := negotiateFormat(request, envelopeFormats); !found {
+		sendError(writer, http.StatusNotAcceptable, codeNotAcceptable,
+			"output format not supported, use one of: "+supportedFormats(envelopeFormats))
+		return
+	}
+	params, offset, paramErr := parseCursor(request.URL.Query())
+	var query model.Query
+	if paramErr == nil {
+		query, paramErr = parseQuery(params)
+	}
+	// the ranking is fetched up to the end of the requested page
+	pageSize := query.Count
+	query.Count = util.Min(offset+pageSize, model.MaxContributors)
+	result, ok := server.rank(writer, request, query, paramErr)
+	if !ok {
+		return
+	}
+
+	envelope := Envelope{
+		Items:       []model.User{},
+		Query:       echoQuery(query, pageSize, offset),
+		TotalCount:  result.TotalCount,
+		Incomplete:  result.Incomplete,
+		GeneratedAt: result.FetchedAt,
+		Cache:       cacheMiss,
+		Stale:       result.Stale,
+	}
+	if result.Cached {
+		envelope.Cache = cacheHit
+	}
+	if offset < len(result.Users) {
+		envelope.Items = result.Users[offset:]
+	}
+	end := offset + pageSize
+	if len(result.Users) >= end && end < util.Min(result.TotalCount, model.MaxContributors) {
+		envelope.NextCursor = encodeCursor(params, end)
+	}
+
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		sendError(writer, http.StatusInternalServerError, codeInternalError,
+			"output representation failed: "+err.Error())
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(body)
+	slog.DebugContext(request.Context(), "Processed request",
+		"results", len(envelope.Items), "cached", result.Cached)
+}
+
+// (private) parseCursor returns the parameters of the request and the
+// offset of the requested page. When a cursor is present, the parameters
+// encoded in it replace the ones in the request
+func parseCursor(params url.Values) (url.Values, int, *paramError) {
+	cursor := params.Get("cursor")
+	if len(cursor) == 0 {
+		return params, 0, nil
+	}
+	invalid := &paramError{codeInvalidParameter, "cursor parameter not valid"}
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, 0, invalid
+	}
+	params, err = url.ParseQuery(string(decoded))
+	if err != nil {
+		return nil, 0, invalid
+	}
+	offset, err := strconv.Atoi(params.Get("offset"))
+	if err != nil || offset < 0 || offset >= model.MaxContributors {
+		return nil, 0, invalid
+	}
+	params.Del("offset")
+	return params, offset, nil
+}
+
+// (private) encodeCursor returns a cursor for the page starting at `offset`
+// of the query described by the given parameters
+func encodeCursor(params url.Values, offset int) string {
+	next := url.Values{}
+	for name, values := range params {
+		if name != "cursor" {
+			next[name] = values
+		}
+	}
+	next.Set("offset", strconv.Itoa(offset))
+	return base64.RawURLEncoding.EncodeToString([]byte(next.Encode()))
+}
+
+// (private) echoQuery returns the description of a query for an Envelope
+func echoQuery(query model.Query, pageSize, offset int) QueryEcho {
+	echo := QueryEcho{
+		City:         query.Location,
+		Count:        pageSize,
+		Offset:       offset,
+		Sort:         query.Sort,
+		Order:        query.Order,
+		Fields:       fieldsBasic,
+		Language:     query.Language,
+		FollowersMin: query.Followers.Min,
+		FollowersMax: query.Followers.Max,
+		ReposMin:     query.Repos.Min,
+		ReposMax:     query.Repos.Max,
+		Type:         query.Type,
+	}
+	if query.Profile {
+		echo.Fields = fieldsProfile
+	}
+	if !query.Created.From.IsZero() {
+		echo.CreatedAfter = query.Created.From.Format(model.DateFormat)
+	}
+	if !query.Created.To.IsZero() {
+		echo.CreatedBefore = query.Created.To.Format(model.DateFormat)
+	}
+	return echo
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// Ranker helper that ranks `Total` users, honouring the query count
+type Ranker struct {
+	Total  int
+	Cached bool
+	Stale  bool
+	Counts []int
+}
+
+func (ranker *Ranker) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	ranker.Counts = append(ranker.Counts, query.Count)
+	n := util.Min(query.Count, ranker.Total)
+	users := make([]model.User, n)
+	for i := 0; i < n; i++ {
+		users[i] = model.User{ID: int64(i), Username: fmt.Sprintf("user_%d", i)}
+	}
+	return &model.Ranking{
+		Users:      users,
+		TotalCount: ranker.Total,
+		FetchedAt:  time.Unix(1500000000, 0),
+		Cached:     ranker.Cached,
+		Stale:      ranker.Stale,
+	}, nil
+}
+
+func getEnvelope(t *testing.T, server *ServerContext, params url.Values) (int, Envelope) {
+	client := http.Client{Timeout: time.Second}
+	response, err := client.Get(fmt.Sprintf("%s/api/v2/top-contributors?%s", server.url(), params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var envelope Envelope
+	if response.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(&envelope); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return response.StatusCode, envelope
+}
+
+func TestV2Envelope(t *testing.T) {
+	ranker := &Ranker{Total: 25}
+	server := createServer(t, ranker)
+	defer server.stop()
+
+	status, envelope := getEnvelope(t, server, url.Values{
+		"city": {"Barcelona"}, "count": {"10"}, "language": {"go"}, "followers_min": {"5"}})
+	if status != http.StatusOK {
+		t.Fatalf("got HTTP code %d", status)
+	}
+	if len(envelope.Items) != 10 || envelope.TotalCount != 25 || envelope.Incomplete ||
+		envelope.Cache != cacheMiss || !envelope.GeneratedAt.Equal(time.Unix(1500000000, 0)) {
+		t.Fatalf("unexpected envelope: %+v", envelope)
+	}
+	query := envelope.Query
+	if query.City != "Barcelona" || query.Count != 10 || query.Offset != 0 ||
+		query.Sort != model.SortRepositories || query.Order != model.OrderDesc ||
+		query.Fields != fieldsBasic || query.Language != "go" ||
+		query.FollowersMin == nil || *query.FollowersMin != 5 || query.FollowersMax != nil {
+		t.Fatalf("unexpected query echo: %+v", query)
+	}
+
+	ranker.Cached = true
+	if _, envelope = getEnvelope(t, server, url.Values{"city": {"Barcelona"}}); envelope.Cache != cacheHit {
+		t.Fatalf("expected cache hit, got %s", envelope.Cache)
+	}
+}
+
+func TestV2Cursor(t *testing.T) {
+	ranker := &Ranker{Total: 25}
+	server := createServer(t, ranker)
+	defer server.stop()
+
+	params := url.Values{"city": {"Barcelona"}, "count": {"10"}, "sort": {"followers"}}
+	var ids []int64
+	for page := 0; ; page++ {
+		status, envelope := getEnvelope(t, server, params)
+		if status != http.StatusOK {
+			t.Fatalf("page %d: got HTTP code %d", page, status)
+		}
+		if envelope.Query.Offset != page*10 || envelope.Query.Sort != model.SortFollowers {
+			t.Fatalf("page %d: unexpected query echo %+v", page, envelope.Query)
+		}
+		for _, user := range envelope.Items {
+			ids = append(ids, user.ID)
+		}
+		if len(envelope.NextCursor) == 0 {
+			break
+		}
+		// the cursor replaces all the other parameters
+		params = url.Values{"city": {"Madrid"}, "cursor": {envelope.NextCursor}}
+	}
+	if len(ids) != 25 {
+		t.Fatalf("expected 25 users, got %d", len(ids))
+	}
+	for i, id := range ids {
+		if id != int64(i) {
+			t.Fatalf("position %d: unexpected user %d", i, id)
+		}
+	}
+	if fmt.Sprint(ranker.Counts) != "[10 20 30]" {
+		t.Fatalf("unexpected upstream counts: %v", ranker.Counts)
+	}
+}
+
+func TestV2CursorLimit(t *testing.T) {
+	ranker := &Ranker{Total: 5000}
+	server := createServer(t, ranker)
+	defer server.stop()
+
+	params := url.Values{"city": {"Barcelona"}, "count": {"600"}}
+	_, first := getEnvelope(t, server, params)
+	if len(first.NextCursor) == 0 {
+		t.Fatal("expected next cursor")
+	}
+	// the last page is cut at the maximum number of results
+	_, last := getEnvelope(t, server, url.Values{"cursor": {first.NextCursor}})
+	if len(last.Items) != 400 || len(last.NextCursor) != 0 {
+		t.Fatalf("unexpected last page: %d items, cursor '%s'", len(last.Items), last.NextCursor)
+	}
+}
+
+func TestV2InvalidCursor(t *testing.T) {
+	server := createServer(t, &Ranker{Total: 25})
+	defer server.stop()
+
+	for _, cursor := range []string{"%%%", encodeCursor(url.Values{"city": {"Barcelona"}}, 1000), "b2Zmc2V0PXg"} {
+		if status, _ := getEnvelope(t, server, url.Values{"cursor": {cursor}}); status != http.StatusBadRequest {
+			t.Fatalf("cursor '%s': got HTTP code %d", cursor, status)
+		}
+	}
+}
+
+func TestV2OnlyGet(t *testing.T) {
+	ranker := &Ranker{Total: 25}
+	server := createServer(t, ranker)
+	defer server.stop()
+
+	client := http.Client{Timeout: time.Second}
+	url := fmt.Sprintf("%s/api/v2/top-contributors?city=Barcelona", server.url())
+	for _, method := range []string{"POST", "PUT", "DELETE"} {
+		request, _ := http.NewRequest(method, url, nil)
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusMethodNotAllowed || response.Header.Get("Allow") != "GET" {
+			t.Fatalf("%s: got HTTP code %d, Allow '%s'", method, response.StatusCode, response.Header.Get("Allow"))
+		}
+	}
+	if len(ranker.Counts) != 0 {
+		t.Fatalf("expected no queries, got %d", len(ranker.Counts))
+	}
+}
+
+func TestV2Formats(t *testing.T) {
+	ranker := &Ranker{Total: 25}
+	server := createServer(t, ranker)
+	defer server.stop()
+
+	url := fmt.Sprintf("%s/api/v2/top-contributors?city=Barcelona", server.url())
+	tests := []struct {
+		format string
+		accept string
+		status int
+	}{
+		{"", "", http.StatusOK},
+		{"", "application/json", http.StatusOK},
+		{"", "text/csv, */*;q=0.1", http.StatusOK},
+		{"json", "", http.StatusOK},
+		{"", "text/csv", http.StatusNotAcceptable},
+		{"xml", "", http.StatusNotAcceptable},
+	}
+	client := http.Client{Timeout: time.Second}
+	for _, tt := range tests {
+		request, _ := http.NewRequest("GET", url+"&format="+tt.format, nil)
+		if len(tt.accept) > 0 {
+			request.Header.Set("Accept", tt.accept)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != tt.status {
+			t.Fatalf("format '%s', accept '%s': expected HTTP code %d, got %d",
+				tt.format, tt.accept, tt.status, response.StatusCode)
+		}
+		if contentType := response.Header.Get("Content-Type"); tt.status == http.StatusOK && contentType != "application/json" {
+			t.Fatalf("unexpected Content-Type %s", contentType)
+		}
+	}
+	// unsupported formats don't query GitHub
+	if len(ranker.Counts) != 4 {
+		t.Fatalf("expected 4 queries, got %d", len(ranker.Counts))
+	}
+}
+
+func TestStaleWarning(t *testing.T) {
+	ranker := &Ranker{Total: 25}
+	server := createServer(t, ranker)
+	defer server.stop()
+
+	client := http.Client{Timeout: time.Second}
+	for _, stale := range []bool{false, true} {
+		ranker.Cached, ranker.Stale = stale, stale
+		response, err := client.Get(fmt.Sprintf("%s/api/top-contributors?city=Barcelona", server.url()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if warning := response.Header.Get("Warning"); (warning == staleWarning) != stale {
+			t.Fatalf("stale:%v unexpected warning '%s'", stale, warning)
+		}
+		if _, envelope := getEnvelope(t, server, url.Values{"city": {"Barcelona"}}); envelope.Stale != stale {
+			t.Fatalf("stale:%v unexpected envelope: %+v", stale, envelope)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/adriansr/github-api-service/scheduler"
+)
+
+// path for the endpoint with the status of watched locations
+const watchesPath = "/api/admin/watches"
+
+// EnableWatches registers the endpoint that reports the status of the
+// locations refreshed by the given scheduler:
+//
+//	GET /api/admin/watches  lists the watches with their next run and the
+//	                        result of the last one
+func (server *Server) EnableWatches(watches *scheduler.Scheduler) {
+	server.watches = watches
+	server.handler.HandleFunc(watchesPath, server.listWatches)
+	slog.Info("Registered API endpoint", "path", watchesPath)
+}
+
+// (private) listWatches handles requests for the status of the watches
+func (server *Server) listWatches(writer http.ResponseWriter, request *http.Request) {
+	setCommonHeaders(writer)
+	if !allowGet(writer, request) {
+		return
+	}
+	sendObject(writer, http.StatusOK, server.watches.Status())
+}
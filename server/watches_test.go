@@ -0,0 +1,30 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/scheduler"
+)
+
+func TestWatchesEndpoint(t *testing.T) {
+	watches, err := scheduler.New(nil, nil, 0, 0, []scheduler.Watch{
+		{Query: model.Query{Location: "Barcelona", Count: 50}, Interval: time.Hour},
+		{Query: model.Query{Location: "Madrid", Count: 10, Sort: model.SortFollowers}, Interval: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := createServer(t, newRecorder(0, nil))
+	defer server.stop()
+	server.server.EnableWatches(watches)
+
+	var status []scheduler.Status
+	if code := getJSON(t, server, "/api/admin/watches", &status); code != 200 || len(status) != 2 {
+		t.Fatalf("got HTTP code %d, %+v", code, status)
+	}
+	if status[1].City != "Madrid" || status[1].Sort != model.SortFollowers || status[1].Interval != "1m0s" {
+		t.Fatalf("unexpected status: %+v", status[1])
+	}
+}
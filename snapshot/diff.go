@@ -0,0 +1,86 @@
+package snapshot
+
+import (
+	"github.com/adriansr/github-api-service/util"
+)
+
+// Diff describes the changes from one snapshot to another. Only the top
+// users up to the smallest count requested by both snapshots are compared,
+// so that rankings of different lengths can be compared
+type Diff struct {
+	From Info `json:"from"`
+	To   Info `json:"to"`
+	// number of positions compared
+	Compared int `json:"compared"`
+	// users only in the newer snapshot
+	Entrants []Movement `json:"entrants"`
+	// users only in the older snapshot
+	Dropouts []Movement `json:"dropouts"`
+	// users in both snapshots with a different rank
+	Moved []Movement `json:"moved"`
+	// number of users in both snapshots with the same rank
+	Unchanged int `json:"unchanged"`
+}
+
+// Movement describes the change in the rank of a user. Ranks start at 1,
+// and are 0 when the user is not in a snapshot. Users are identified by ID,
+// so that a renamed user is reported with its new name, and its previous
+// name in PreviousName
+type Movement struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	PreviousName string `json:"previous_name,omitempty"`
+	FromRank     int    `json:"from_rank,omitempty"`
+	ToRank       int    `json:"to_rank,omitempty"`
+	// positions gained, negative when the user moved down
+	Change int `json:"change,omitempty"`
+}
+
+// Compare returns the changes from one snapshot to another, which must be
+// of the same query
+func Compare(from, to *Snapshot) (*Diff, error) {
+	if from.Query != to.Query {
+		return nil, util.NewErrorKind(util.InvalidQuery, "snapshots are from different queries")
+	}
+	n := util.Min(from.Count, to.Count)
+	fromUsers := from.Users[:util.Min(n, len(from.Users))]
+	toUsers := to.Users[:util.Min(n, len(to.Users))]
+	diff := &Diff{
+		From:     from.Info,
+		To:       to.Info,
+		Compared: n,
+		Entrants: []Movement{},
+		Dropouts: []Movement{},
+		Moved:    []Movement{},
+	}
+
+	fromRanks := make(map[int64]int, len(fromUsers))
+	for i, user := range fromUsers {
+		fromRanks[user.ID] = i + 1
+	}
+	toRanks := make(map[int64]int, len(toUsers))
+	for i, user := range toUsers {
+		rank := i + 1
+		toRanks[user.ID] = rank
+		fromRank, found := fromRanks[user.ID]
+		if !found {
+			diff.Entrants = append(diff.Entrants, Movement{ID: user.ID, Name: user.Username, ToRank: rank})
+			continue
+		}
+		if fromRank == rank {
+			diff.Unchanged++
+			continue
+		}
+		movement := Movement{ID: user.ID, Name: user.Username, FromRank: fromRank, ToRank: rank, Change: fromRank - rank}
+		if previous := fromUsers[fromRank-1].Username; previous != user.Username {
+			movement.PreviousName = previous
+		}
+		diff.Moved = append(diff.Moved, movement)
+	}
+	for i, user := range fromUsers {
+		if _, found := toRanks[user.ID]; !found {
+			diff.Dropouts = append(diff.Dropouts, Movement{ID: user.ID, Name: user.Username, FromRank: i + 1})
+		}
+	}
+	return diff, nil
+}
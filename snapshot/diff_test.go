@@ -0,0 +1,71 @@
+package snapshot
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+func makeSnapshot(count int, users ...string) *Snapshot {
+	snapshot := &Snapshot{Info: Info{Query: "barcelona", Count: count}}
+	for _, user := range users {
+		var id int64
+		var name string
+		fmt.Sscanf(user, "%d:%s", &id, &name)
+		snapshot.Users = append(snapshot.Users, model.User{ID: id, Username: name})
+	}
+	return snapshot
+}
+
+func TestCompare(t *testing.T) {
+	from := makeSnapshot(5, "1:a", "2:b", "3:c", "4:d", "5:e")
+	to := makeSnapshot(5, "3:c", "1:a", "6:f", "4:renamed", "7:g")
+	diff, err := Compare(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Compared != 5 || diff.Unchanged != 1 {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+	if fmt.Sprint(diff.Entrants) != "[{6 f  0 3 0} {7 g  0 5 0}]" {
+		t.Fatalf("unexpected entrants: %v", diff.Entrants)
+	}
+	if fmt.Sprint(diff.Dropouts) != "[{2 b  2 0 0} {5 e  5 0 0}]" {
+		t.Fatalf("unexpected dropouts: %v", diff.Dropouts)
+	}
+	if fmt.Sprint(diff.Moved) != "[{3 c  3 1 2} {1 a  1 2 -1}]" {
+		t.Fatalf("unexpected movements: %v", diff.Moved)
+	}
+}
+
+func TestCompareRenamed(t *testing.T) {
+	from := makeSnapshot(3, "1:a", "2:b")
+	to := makeSnapshot(3, "2:renamed", "1:a")
+	diff, _ := Compare(from, to)
+	if len(diff.Entrants) != 0 || len(diff.Dropouts) != 0 {
+		t.Fatalf("renamed user reported as new: %+v", diff)
+	}
+	if diff.Moved[0].Name != "renamed" || diff.Moved[0].PreviousName != "b" {
+		t.Fatalf("unexpected movement: %+v", diff.Moved[0])
+	}
+}
+
+func TestCompareDifferentCounts(t *testing.T) {
+	from := makeSnapshot(2, "1:a", "2:b")
+	to := makeSnapshot(4, "1:a", "3:c", "2:b", "4:d")
+	diff, _ := Compare(from, to)
+	// only the top 2 are compared
+	if diff.Compared != 2 || len(diff.Entrants) != 1 || len(diff.Dropouts) != 1 || diff.Unchanged != 1 {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+}
+
+func TestCompareDifferentQueries(t *testing.T) {
+	from := makeSnapshot(2, "1:a")
+	to := makeSnapshot(2, "1:a")
+	to.Query = "madrid"
+	if _, err := Compare(from, to); err == nil {
+		t.Fatal("failure expected")
+	}
+}
@@ -0,0 +1,327 @@
+// Package snapshot keeps a history of the rankings fetched for every city,
+// so that their evolution can be tracked by comparing snapshots
+package snapshot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+	"github.com/adriansr/github-api-service/util"
+)
+
+// ErrNotFound is returned for unknown snapshots
+var ErrNotFound = util.NewError("snapshot not found")
+
+// valid snapshot identifiers: a hexadecimal timestamp and random suffix
+var validID = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// Info describes a snapshot
+type Info struct {
+	ID string `json:"id"`
+	// normalized location
+	City  string `json:"city"`
+	Sort  string `json:"sort"`
+	Order string `json:"order"`
+	// canonical representation of the query, see model.Query.Key
+	Query string `json:"query"`
+	// count requested
+	Count      int       `json:"count"`
+	TotalCount int       `json:"total_count"`
+	TakenAt    time.Time `json:"taken_at"`
+}
+
+// Snapshot is a ranking recorded at a given time
+type Snapshot struct {
+	Info
+	Users []model.User `json:"users"`
+}
+
+// Store keeps up to a maximum number of snapshots per query of every city,
+// discarding the oldest ones, so that the queries of a city don't evict the
+// history of each other. Snapshots are saved as files in a directory, or kept
+// in memory if no directory is given
+type Store struct {
+	dir         string
+	maxPerQuery int
+
+	// protects all fields below
+	mutex sync.Mutex
+	// snapshots of every city, oldest first
+	cities map[string][]Info
+	// contents of the snapshots when not saved to files
+	memory map[string]*Snapshot
+
+	// source of time, replaceable for testing
+	now func() time.Time
+}
+
+// NewStore returns a Store that saves the snapshots in `dir`, or in memory
+// if empty, keeping up to `maxPerQuery` snapshots per query. Snapshots already
+// saved in the directory are loaded, skipping corrupt ones
+func NewStore(dir string, maxPerQuery int) (*Store, error) {
+	store := &Store{
+		dir:         dir,
+		maxPerQuery: util.Max(maxPerQuery, 1),
+		cities:      make(map[string][]Info),
+		memory:      make(map[string]*Snapshot),
+		now:         time.Now,
+	}
+	if len(dir) > 0 {
+		if err := store.load(); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Save records a ranking fetched for the query
+func (store *Store) Save(query model.Query, ranking *model.Ranking) (Info, error) {
+	id, err := store.newID()
+	if err != nil {
+		return Info{}, util.WrapError("failed to create snapshot", err)
+	}
+	sort, order := query.SortOrder()
+	snapshot := &Snapshot{
+		Info: Info{
+			ID:         id,
+			City:       model.NormalizeLocation(query.Location),
+			Sort:       sort,
+			Order:      order,
+			Query:      query.Key(),
+			Count:      query.Count,
+			TotalCount: ranking.TotalCount,
+			TakenAt:    store.now(),
+		},
+		Users: ranking.Users,
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if len(store.dir) > 0 {
+		if err := store.write(snapshot); err != nil {
+			return Info{}, err
+		}
+	} else {
+		store.memory[id] = snapshot
+	}
+	city := append(store.cities[snapshot.City], snapshot.Info)
+	store.cities[snapshot.City] = store.trim(city, snapshot.Query)
+	return snapshot.Info, nil
+}
+
+// (private) recent returns if a snapshot of the query, for at least its
+// count, was taken less than `interval` ago
+func (store *Store) recent(query model.Query, interval time.Duration) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	key, since := query.Key(), store.now().Add(-interval)
+	infos := store.cities[model.NormalizeLocation(query.Location)]
+	for i := len(infos) - 1; i >= 0 && infos[i].TakenAt.After(since); i-- {
+		if infos[i].Query == key && infos[i].Count >= query.Count {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the snapshots of a city, newest first
+func (store *Store) List(city string) []Info {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	infos := store.cities[model.NormalizeLocation(city)]
+	result := make([]Info, len(infos))
+	for i, info := range infos {
+		result[len(infos)-1-i] = info
+	}
+	return result
+}
+
+// Get returns the snapshot with the given ID, or ErrNotFound
+func (store *Store) Get(id string) (*Snapshot, error) {
+	if !validID.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if len(store.dir) == 0 {
+		snapshot, found := store.memory[id]
+		if !found {
+			return nil, ErrNotFound
+		}
+		return snapshot, nil
+	}
+	snapshot, err := store.read(id)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return snapshot, err
+}
+
+// (private) newID returns a new snapshot identifier, which sorts by
+// creation time
+func (store *Store) newID() (string, error) {
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%016x%s", store.now().UnixNano(), hex.EncodeToString(suffix[:])), nil
+}
+
+// (private) path returns the file of a snapshot
+func (store *Store) path(id string) string {
+	return filepath.Join(store.dir, id+".json")
+}
+
+// (private) write saves a snapshot to its file, atomically
+func (store *Store) write(snapshot *Snapshot) error {
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return util.WrapError("failed to encode snapshot", err)
+	}
+	temp := store.path(snapshot.ID) + ".tmp"
+	if err := ioutil.WriteFile(temp, content, 0600); err != nil {
+		return util.WrapError("failed to write snapshot", err)
+	}
+	if err := os.Rename(temp, store.path(snapshot.ID)); err != nil {
+		return util.WrapError("failed to write snapshot", err)
+	}
+	return nil
+}
+
+// (private) read loads a snapshot from its file
+func (store *Store) read(id string) (*Snapshot, error) {
+	content, err := ioutil.ReadFile(store.path(id))
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, util.WrapError("corrupt snapshot "+id, err)
+	}
+	if snapshot.ID != id {
+		return nil, util.NewError("corrupt snapshot " + id)
+	}
+	return &snapshot, nil
+}
+
+// (private) trim discards the oldest snapshots of a query from the snapshots
+// of a city, oldest first, while there are more than the maximum. Must be
+// called with the mutex held
+func (store *Store) trim(infos []Info, query string) []Info {
+	count := 0
+	for _, info := range infos {
+		if info.Query == query {
+			count++
+		}
+	}
+	result := infos[:0]
+	for _, info := range infos {
+		if info.Query == query && count > store.maxPerQuery {
+			store.remove(info.ID)
+			count--
+			continue
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+// (private) remove discards a snapshot. Must be called with the mutex held
+func (store *Store) remove(id string) {
+	if len(store.dir) == 0 {
+		delete(store.memory, id)
+		return
+	}
+	if err := os.Remove(store.path(id)); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove snapshot", "id", id, "error", err)
+	}
+}
+
+// (private) load indexes the snapshots saved in the directory, enforcing
+// the maximum number per query
+func (store *Store) load() error {
+	if err := os.MkdirAll(store.dir, 0700); err != nil {
+		return util.WrapError("failed to create snapshots directory", err)
+	}
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return util.WrapError("failed to read snapshots directory", err)
+	}
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), ".json")
+		if !validID.MatchString(id) || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		snapshot, err := store.read(id)
+		if err != nil {
+			slog.Warn("Skipping snapshot", "error", err)
+			continue
+		}
+		store.cities[snapshot.City] = append(store.cities[snapshot.City], snapshot.Info)
+	}
+	for city, infos := range store.cities {
+		sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+		queries := make(map[string]bool)
+		for _, info := range infos {
+			queries[info.Query] = true
+		}
+		for query := range queries {
+			infos = store.trim(infos, query)
+		}
+		store.cities[city] = infos
+	}
+	return nil
+}
+
+// Recorder is a TopContributorGetter that saves a snapshot of every
+// complete ranking obtained from another TopContributorGetter. It should be
+// placed below any cache, so that only fetched rankings are recorded
+type Recorder struct {
+	getter model.TopContributorGetter
+	store  *Store
+	// rankings are not recorded again for a query within this interval
+	minInterval time.Duration
+}
+
+// NewRecorder returns a Recorder that forwards queries to the given getter
+// and saves the results in the store
+func NewRecorder(getter model.TopContributorGetter, store *Store) *Recorder {
+	return &Recorder{getter: getter, store: store}
+}
+
+// SetMinInterval changes the time during which a query is not recorded
+// again, unless for a larger count, e.g. while pages of the same ranking
+// are fetched. Not safe to call while queries are in progress
+func (recorder *Recorder) SetMinInterval(interval time.Duration) {
+	recorder.minInterval = interval
+}
+
+// GetTopContributors forwards the query to the underlying getter and saves
+// a snapshot of the result, unless one was recently saved for the query.
+// Failing to save the snapshot doesn't fail the query
+func (recorder *Recorder) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	ranking, err := recorder.getter.GetTopContributors(ctx, query)
+	if err != nil || ranking.Incomplete || ranking.Cached {
+		return ranking, err
+	}
+	if recorder.minInterval > 0 && recorder.store.recent(query, recorder.minInterval) {
+		return ranking, nil
+	}
+	if _, err := recorder.store.Save(query, ranking); err != nil {
+		slog.ErrorContext(ctx, "Failed to save snapshot", "error", err)
+	}
+	return ranking, nil
+}
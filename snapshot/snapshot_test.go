@@ -0,0 +1,185 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adriansr/github-api-service/model"
+)
+
+// Clock helper to control the passing of time
+type Clock struct {
+	current time.Time
+}
+
+func (clock *Clock) now() time.Time {
+	clock.current = clock.current.Add(time.Second)
+	return clock.current
+}
+
+func newStore(t *testing.T, dir string, maxPerCity int) *Store {
+	store, err := NewStore(dir, maxPerCity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.now = (&Clock{time.Unix(1500000000, 0)}).now
+	return store
+}
+
+func ranking(ids ...int64) *model.Ranking {
+	users := make([]model.User, len(ids))
+	for i, id := range ids {
+		users[i] = model.User{ID: id, Username: "user"}
+	}
+	return &model.Ranking{Users: users, TotalCount: 100}
+}
+
+func save(t *testing.T, store *Store, location string, ids ...int64) Info {
+	info, err := store.Save(model.Query{Location: location, Count: 10}, ranking(ids...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func testStore(t *testing.T, dir string) {
+	store := newStore(t, dir, 2)
+	first := save(t, store, "Barcelona", 1, 2)
+	second := save(t, store, " barcelona", 2, 1)
+	save(t, store, "Madrid", 3)
+
+	infos := store.List("BARCELONA")
+	if len(infos) != 2 || infos[0].ID != second.ID || infos[1].ID != first.ID {
+		t.Fatalf("unexpected snapshots: %+v", infos)
+	}
+	if infos[0].City != "barcelona" || infos[0].Count != 10 || infos[0].TotalCount != 100 ||
+		infos[0].Sort != model.SortRepositories || !infos[0].TakenAt.After(infos[1].TakenAt) {
+		t.Fatalf("unexpected info: %+v", infos[0])
+	}
+	snapshot, err := store.Get(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Users) != 2 || snapshot.Users[0].ID != 1 {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+
+	// the oldest snapshot is discarded
+	third := save(t, store, "Barcelona", 3)
+	if infos := store.List("Barcelona"); len(infos) != 2 || infos[0].ID != third.ID {
+		t.Fatalf("unexpected snapshots: %+v", infos)
+	}
+	if _, err := store.Get(first.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	for _, id := range []string{"unknown", "../../etc/passwd", "0123456789abcdef01234567"} {
+		if _, err := store.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: expected not found, got %v", id, err)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, "")
+}
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testStore(t, dir)
+
+	// snapshots are available after a restart
+	ioutil.WriteFile(filepath.Join(dir, "0123456789abcdef01234567.json"), []byte("{corrupt"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "unrelated.txt"), []byte("hello"), 0600)
+	store := newStore(t, dir, 1)
+	infos := store.List("Barcelona")
+	if len(infos) != 1 || len(store.List("Madrid")) != 1 {
+		t.Fatalf("unexpected snapshots: %+v", infos)
+	}
+	if snapshot, err := store.Get(infos[0].ID); err != nil || snapshot.Users[0].ID != 3 {
+		t.Fatalf("unexpected snapshot: %+v (%v)", snapshot, err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	// 2 snapshots, the corrupt one and the unrelated file
+	if len(files) != 4 {
+		t.Fatalf("expected 4 files, got %d", len(files))
+	}
+}
+
+// Fixed helper that returns the same ranking for every query
+type Fixed struct {
+	Ranking *model.Ranking
+}
+
+func (fixed *Fixed) GetTopContributors(ctx context.Context, query model.Query) (*model.Ranking, error) {
+	return fixed.Ranking, nil
+}
+
+func TestRecorder(t *testing.T) {
+	store := newStore(t, "", 10)
+	fixed := &Fixed{ranking(1, 2, 3)}
+	recorder := NewRecorder(fixed, store)
+
+	query := model.Query{Location: "Barcelona", Count: 10}
+	recorder.GetTopContributors(context.Background(), query)
+	fixed.Ranking = ranking(1)
+	fixed.Ranking.Incomplete = true
+	recorder.GetTopContributors(context.Background(), query)
+	fixed.Ranking = ranking(1)
+	fixed.Ranking.Cached = true
+	recorder.GetTopContributors(context.Background(), query)
+	if infos := store.List("Barcelona"); len(infos) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(infos))
+	}
+}
+
+func TestStoreKeepsEveryQuery(t *testing.T) {
+	store := newStore(t, "", 1)
+	first := save(t, store, "Barcelona", 1)
+	followers, err := store.Save(model.Query{Location: "Barcelona", Count: 10, Sort: model.SortFollowers}, ranking(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infos := store.List("Barcelona"); len(infos) != 2 {
+		t.Fatalf("unexpected snapshots: %+v", infos)
+	}
+
+	// only the oldest snapshot of the same query is discarded
+	second := save(t, store, "Barcelona", 3)
+	if infos := store.List("Barcelona"); len(infos) != 2 || infos[0].ID != second.ID || infos[1].ID != followers.ID {
+		t.Fatalf("unexpected snapshots: %+v", infos)
+	}
+	if _, err := store.Get(first.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestRecorderMinInterval(t *testing.T) {
+	store := newStore(t, "", 10)
+	clock := &Clock{time.Unix(1500000000, 0)}
+	store.now = clock.now
+	recorder := NewRecorder(&Fixed{ranking(1, 2, 3)}, store)
+	recorder.SetMinInterval(time.Minute)
+
+	for _, count := range []int{10, 10, 5, 20} {
+		recorder.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: count})
+	}
+	// a larger count is recorded again
+	if infos := store.List("Barcelona"); len(infos) != 2 || infos[0].Count != 20 || infos[1].Count != 10 {
+		t.Fatalf("unexpected snapshots: %+v", infos)
+	}
+
+	clock.current = clock.current.Add(time.Minute)
+	recorder.GetTopContributors(context.Background(), model.Query{Location: "Barcelona", Count: 10})
+	if infos := store.List("Barcelona"); len(infos) != 3 {
+		t.Fatalf("expected 3 snapshots, got %d", len(infos))
+	}
+}
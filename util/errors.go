@@ -1,17 +1,139 @@
 package util
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
+	"time"
 )
 
+// Kind classifies errors according to their origin, so that they can be
+// reported appropriately. A Kind is itself an error, so that the kind of an
+// error can be checked with errors.Is(err, util.RateLimited)
+type Kind int
+
+const (
+	// Internal is the kind of any unclassified error
+	Internal Kind = iota
+	// InvalidQuery errors are caused by a query that can't be performed
+	InvalidQuery
+	// RateLimited errors are caused by an upstream API rate limit
+	RateLimited
+	// Overloaded errors are returned when too many queries are pending
+	Overloaded
+	// Unavailable errors are caused by the upstream API failing or not
+	// being reachable
+	Unavailable
+	// Timeout errors are caused by the upstream API not replying on time
+	Timeout
+	// Decode errors are caused by an unexpected upstream response
+	Decode
+)
+
+var kindNames = map[Kind]string{
+	Internal:     "internal error",
+	InvalidQuery: "invalid query",
+	RateLimited:  "upstream rate limited",
+	Overloaded:   "service overloaded",
+	Unavailable:  "upstream unavailable",
+	Timeout:      "upstream timeout",
+	Decode:       "decode failure",
+}
+
+func (kind Kind) Error() string {
+	return kindNames[kind]
+}
+
+// Error is an error of a given Kind, optionally caused by another error
+type Error struct {
+	Kind    Kind
+	Message string
+	Cause   error
+	// RetryAfter is the time after which the failed operation is expected
+	// to succeed, for RateLimited and Overloaded errors
+	RetryAfter time.Duration
+}
+
+func (err *Error) Error() string {
+	message := err.Message
+	if err.RetryAfter > 0 {
+		message = fmt.Sprintf("%s (retry after %s)", message, err.RetryAfter)
+	}
+	if err.Cause != nil {
+		return fmt.Sprintf("%s [caused by: %s]", message, err.Cause.Error())
+	}
+	return message
+}
+
+// Unwrap returns the cause of the error
+func (err *Error) Unwrap() error {
+	return err.Cause
+}
+
+// Is reports whether the error is of the given Kind
+func (err *Error) Is(target error) bool {
+	kind, ok := target.(Kind)
+	return ok && kind == err.Kind
+}
+
+// ErrQueueFull is returned when a query is rejected because there are too
+// many queries waiting to be processed
+var ErrQueueFull error = &Error{Kind: Overloaded, Message: "too many pending queries",
+	RetryAfter: time.Second}
+
 // WrapError decorates an existing error (possibly returned by a 3rd party
-// library) with the given message, for context
+// library) with the given message, for context. The resulting error keeps
+// the Kind of its cause
 func WrapError(message string, cause error) error {
-	return fmt.Errorf("%s [caused by: %s]", message, cause.Error())
+	return &Error{Kind: KindOf(cause), Message: message, Cause: cause}
+}
+
+// WrapErrorKind is like WrapError, but sets the Kind of the resulting error
+func WrapErrorKind(kind Kind, message string, cause error) error {
+	return &Error{Kind: kind, Message: message, Cause: cause}
 }
 
 // NewError creates an error that prints the given string
 func NewError(message string) error {
 	return errors.New(message)
 }
+
+// NewErrorKind creates an error of the given Kind that prints the given
+// string
+func NewErrorKind(kind Kind, message string) error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// NewRateLimitError creates a RateLimited error with the given message and
+// retry delay
+func NewRateLimitError(message string, retryAfter time.Duration) error {
+	return &Error{Kind: RateLimited, Message: message, RetryAfter: retryAfter}
+}
+
+// KindOf returns the Kind of an error. Errors not created by this package
+// are Internal, except for timeouts
+func KindOf(err error) Kind {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed.Kind
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return Timeout
+	}
+	return Internal
+}
+
+// RetryAfter returns the retry delay of an error, or zero if it has none
+func RetryAfter(err error) time.Duration {
+	var typed *Error
+	for errors.As(err, &typed) {
+		if typed.RetryAfter > 0 {
+			return typed.RetryAfter
+		}
+		err = typed.Cause
+	}
+	return 0
+}
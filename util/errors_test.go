@@ -0,0 +1,67 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWrapErrorKeepsCause(t *testing.T) {
+	cause := errors.New("cause")
+	err := WrapError("message", cause)
+	if err.Error() != "message [caused by: cause]" {
+		t.Fatalf("wrong message: '%s'", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatal("cause lost")
+	}
+	if KindOf(err) != Internal {
+		t.Fatalf("wrong kind: %v", KindOf(err))
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind Kind
+	}{
+		{errors.New("plain"), Internal},
+		{NewErrorKind(InvalidQuery, "invalid"), InvalidQuery},
+		{NewRateLimitError("limited", time.Second), RateLimited},
+		{ErrQueueFull, Overloaded},
+		{WrapErrorKind(Decode, "decode", errors.New("eof")), Decode},
+		{WrapError("context", NewErrorKind(Unavailable, "down")), Unavailable},
+		{fmt.Errorf("fmt wrapped: %w", NewErrorKind(Timeout, "slow")), Timeout},
+		{context.DeadlineExceeded, Timeout},
+		{WrapError("request", context.DeadlineExceeded), Timeout},
+		{context.Canceled, Internal},
+	}
+	for _, tt := range tests {
+		if kind := KindOf(tt.err); kind != tt.kind {
+			t.Errorf("'%s': expected kind '%s', got '%s'", tt.err, tt.kind, kind)
+		}
+		var typed *Error
+		if errors.As(tt.err, &typed) && !errors.Is(tt.err, tt.kind) {
+			t.Errorf("'%s': errors.Is failed for kind '%s'", tt.err, tt.kind)
+		}
+	}
+}
+
+func TestErrorsAs(t *testing.T) {
+	err := WrapError("outer", NewRateLimitError("limited", time.Minute))
+	var typed *Error
+	if !errors.As(err, &typed) {
+		t.Fatal("errors.As failed")
+	}
+	if typed.Kind != RateLimited {
+		t.Fatalf("wrong kind: %s", typed.Kind)
+	}
+	if RetryAfter(err) != time.Minute {
+		t.Fatalf("wrong retry after: %s", RetryAfter(err))
+	}
+	if RetryAfter(errors.New("plain")) != 0 {
+		t.Fatal("unexpected retry after")
+	}
+}
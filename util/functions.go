@@ -2,6 +2,8 @@
 // of the project
 package util
 
+import "time"
+
 // Min returns the minimum of two ints
 func Min(a, b int) int {
 	if a < b {
@@ -17,3 +19,11 @@ func Max(a, b int) int {
 	}
 	return b
 }
+
+// MaxDuration returns the maximum of two durations
+func MaxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}